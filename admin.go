@@ -0,0 +1,39 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	// errAdminTokenNotConfigured indicates an administrative request was made
+	// but no admin token is configured.
+	errAdminTokenNotConfigured = errors.New("administrative requests are disabled (set ADMIN_TOKEN to enable them)")
+	// errInvalidAdminToken indicates an administrative request was made
+	// without the admin token.
+	errInvalidAdminToken = errors.New("invalid or missing admin token")
+)
+
+// checkAdminToken checks that the request carries the configured admin token
+// in an "Authorization: Bearer" header. The token doesn't depend on the JWKS
+// cache, so it works when JWT assertions can't be verified.
+func (srv *Server) checkAdminToken(r *http.Request) error {
+	if srv.cfg.adminToken == "" {
+		return errAdminTokenNotConfigured
+	}
+
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return errInvalidAdminToken
+	}
+	// compare hashes so the comparison doesn't leak the token's length
+	expected := sha256.Sum256([]byte(srv.cfg.adminToken))
+	actual := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	if subtle.ConstantTimeCompare(expected[:], actual[:]) != 1 {
+		return errInvalidAdminToken
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// caCertsWatchInterval is how often CA cert files are checked for changes.
+const caCertsWatchInterval = jwksFileWatchInterval
+
+var errNoCACerts = errors.New("no CA certs found in file")
+
+// A caCertFile describes a CA cert file and the certificates loaded from it.
+type caCertFile struct {
+	// Issuer is the trusted issuer the file is used for, if any.
+	Issuer string `json:"issuer,omitempty"`
+	Path   string `json:"path"`
+	// LoadedAt is when the current contents of the file were loaded.
+	LoadedAt *time.Time `json:"loadedAt,omitempty"`
+	// Error is set when the file couldn't be loaded, in which case it is left
+	// out of the pool.
+	Error        string       `json:"error,omitempty"`
+	Certificates []caCertInfo `json:"certificates"`
+
+	raw   []byte
+	certs []*x509.Certificate
+}
+
+// A caCertInfo describes a CA cert loaded from a file.
+type caCertInfo struct {
+	Subject           string    `json:"subject"`
+	SHA256Fingerprint string    `json:"sha256Fingerprint"`
+	NotAfter          time.Time `json:"notAfter"`
+}
+
+// A caCertPool is a pool of root CAs loaded from files, optionally on top of
+// the system roots. The pool is rebuilt whenever the files change.
+type caCertPool struct {
+	paths  []string
+	system bool
+
+	mu    sync.RWMutex
+	pool  *x509.CertPool
+	files []caCertFile
+	// fingerprints maps the fingerprint of each cert to its file's path
+	fingerprints map[string]string
+}
+
+func newCACertPool(paths []string, system bool) *caCertPool {
+	p := &caCertPool{
+		paths:  paths,
+		system: system,
+	}
+	p.load()
+	return p
+}
+
+// get returns the current pool and the path each cert was loaded from, keyed
+// by fingerprint.
+func (p *caCertPool) get() (*x509.CertPool, map[string]string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.pool, p.fingerprints
+}
+
+// Files returns the status of each CA cert file.
+func (p *caCertPool) Files() []caCertFile {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return append([]caCertFile(nil), p.files...)
+}
+
+// load reads the CA cert files and rebuilds the pool if any of them changed.
+// Files that can't be read or contain no certs are left out of the pool.
+func (p *caCertPool) load() bool {
+	p.mu.RLock()
+	previous := p.files
+	p.mu.RUnlock()
+
+	changed := previous == nil
+	files := make([]caCertFile, len(p.paths))
+	for i, path := range p.paths {
+		var prev *caCertFile
+		if i < len(previous) {
+			prev = &previous[i]
+		}
+		files[i] = loadCACertFile(path, prev)
+		if prev == nil || prev.Error != files[i].Error || !bytes.Equal(prev.raw, files[i].raw) {
+			changed = true
+			if files[i].Error != "" {
+				log.Error().Str("path", path).Str("error", files[i].Error).Msg("failed to load CA cert file, leaving it out of the pool")
+			} else {
+				log.Info().Str("path", path).Int("certs", len(files[i].certs)).Msg("loaded CA cert file")
+			}
+		}
+	}
+	if !changed {
+		return false
+	}
+
+	pool := x509.NewCertPool()
+	if p.system {
+		if systemPool, err := x509.SystemCertPool(); err == nil {
+			pool = systemPool
+		} else {
+			log.Error().Err(err).Msg("failed to load system CA certs")
+		}
+	}
+	fingerprints := make(map[string]string)
+	for _, f := range files {
+		for _, cert := range f.certs {
+			pool.AddCert(cert)
+			fingerprints[getCertificateFingerprint(cert)] = f.Path
+		}
+	}
+
+	p.mu.Lock()
+	p.pool, p.files, p.fingerprints = pool, files, fingerprints
+	p.mu.Unlock()
+	return true
+}
+
+// watch reloads the CA cert files whenever they change, until the context is
+// canceled.
+func (p *caCertPool) watch(ctx context.Context) {
+	ticker := time.NewTicker(caCertsWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if p.load() {
+			log.Info().Strs("paths", p.paths).Msg("reloaded CA certs")
+		}
+	}
+}
+
+// loadCACertFile loads the PEM encoded certs in a file. The previous load is
+// reused if the file hasn't changed.
+func loadCACertFile(path string, prev *caCertFile) caCertFile {
+	f := caCertFile{Path: path, Certificates: []caCertInfo{}}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	if prev != nil && prev.Error == "" && bytes.Equal(prev.raw, bs) {
+		return *prev
+	}
+
+	f.raw = bs
+	for rest := bs; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" || len(block.Headers) != 0 {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		f.certs = append(f.certs, cert)
+		f.Certificates = append(f.Certificates, caCertInfo{
+			Subject:           cert.Subject.String(),
+			SHA256Fingerprint: getCertificateFingerprint(cert),
+			NotAfter:          cert.NotAfter,
+		})
+	}
+	if len(f.certs) == 0 {
+		f.Error = errNoCACerts.Error()
+		f.certs, f.Certificates = nil, []caCertInfo{}
+		return f
+	}
+	now := time.Now()
+	f.LoadedAt = &now
+	return f
+}
+
+// getCACertPools returns the CA cert pools used by the issuer verifiers.
+func (srv *Server) getCACertPools() []*caCertPool {
+	var pools []*caCertPool
+	seen := map[*caCertPool]bool{}
+	for _, iv := range srv.getAllIssuerVerifiers() {
+		if pool := iv.tlsVerifier.caCerts; pool != nil && !seen[pool] {
+			seen[pool] = true
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+// getCACertFiles returns the status of every CA cert file, labeled with the
+// trusted issuer it is used for.
+func (srv *Server) getCACertFiles() []caCertFile {
+	files := []caCertFile{}
+	seen := map[*caCertPool]bool{}
+	for _, iv := range srv.getAllIssuerVerifiers() {
+		pool := iv.tlsVerifier.caCerts
+		if pool == nil || seen[pool] {
+			continue
+		}
+		seen[pool] = true
+
+		for _, f := range pool.Files() {
+			if iv != srv.defaultVerifier {
+				f.Issuer = iv.issuer
+			}
+			files = append(files, f)
+		}
+	}
+	return files
+}
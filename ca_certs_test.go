@@ -0,0 +1,90 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCACertPoolReload(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(tlsSrv.Close)
+	u, err := url.Parse(tlsSrv.URL)
+	require.NoError(t, err)
+	caPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: tlsSrv.Certificate().Raw,
+	})
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	srv := newTestServer(t, WithExtraCACerts(caPath))
+	pool := srv.defaultVerifier.tlsVerifier.caCerts
+	require.NotNil(t, pool)
+
+	dial := func(t *testing.T) error {
+		t.Helper()
+
+		conn, err := srv.defaultVerifier.tlsVerifier.DialTLSContext(context.Background(), "tcp", u.Host)
+		require.NoError(t, err)
+		_ = conn.Close()
+		return srv.defaultVerifier.tlsVerifier.GetTLSError(u.Hostname())
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		files := pool.Files()
+		require.Len(t, files, 1)
+		assert.Equal(t, caPath, files[0].Path)
+		assert.NotEmpty(t, files[0].Error)
+		assert.Nil(t, files[0].LoadedAt)
+		assert.Error(t, dial(t))
+	})
+	t.Run("added", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(caPath, caPEM, 0o600))
+		assert.True(t, pool.load())
+		assert.False(t, pool.load(), "unchanged files should not rebuild the pool")
+
+		files := pool.Files()
+		require.Len(t, files, 1)
+		assert.Empty(t, files[0].Error)
+		assert.NotNil(t, files[0].LoadedAt)
+		require.Len(t, files[0].Certificates, 1)
+		assert.Equal(t, getCertificateFingerprint(tlsSrv.Certificate()), files[0].Certificates[0].SHA256Fingerprint)
+		assert.NoError(t, dial(t))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(caPath, []byte("not a certificate"), 0o600))
+		assert.True(t, pool.load())
+
+		files := pool.Files()
+		require.Len(t, files, 1)
+		assert.Equal(t, errNoCACerts.Error(), files[0].Error)
+		assert.Error(t, dial(t), "an invalid file should be left out of the pool")
+	})
+	t.Run("verify info", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(caPath, caPEM, 0o600))
+		assert.True(t, pool.load())
+
+		r := httptest.NewRequest(http.MethodGet, "/api/verify-info", nil)
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			CACerts []caCertFile `json:"caCerts"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.Len(t, res.CACerts, 1)
+		assert.Equal(t, caPath, res.CACerts[0].Path)
+		assert.NotNil(t, res.CACerts[0].LoadedAt)
+		require.Len(t, res.CACerts[0].Certificates, 1)
+	})
+}
@@ -0,0 +1,161 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+const claimHeaderPrefix = "x-pomerium-claim-"
+
+// claim header check results
+type claimHeaderStatus string
+
+const (
+	claimHeaderStatusMatch    claimHeaderStatus = "match"
+	claimHeaderStatusMismatch claimHeaderStatus = "mismatch"
+	// the JWT has the claim but there is no header for it
+	claimHeaderStatusMissingHeader claimHeaderStatus = "missing_header"
+	// there is a header but the JWT has no such claim
+	claimHeaderStatusMissingClaim claimHeaderStatus = "missing_claim"
+)
+
+// registeredClaims are the standard JWT claims, which Pomerium doesn't pass as
+// claim headers.
+var registeredClaims = map[string]bool{
+	"aud": true,
+	"exp": true,
+	"iat": true,
+	"iss": true,
+	"jti": true,
+	"nbf": true,
+	"sub": true,
+}
+
+// A claimHeaderCheck compares an X-Pomerium-Claim-* header with the
+// corresponding signed JWT claim.
+type claimHeaderCheck struct {
+	Header      string            `json:"header,omitempty"`
+	Claim       string            `json:"claim"`
+	Status      claimHeaderStatus `json:"status"`
+	HeaderValue string            `json:"headerValue,omitempty"`
+	ClaimValue  string            `json:"claimValue,omitempty"`
+}
+
+// A claimHeadersReport describes whether the claim headers are consistent
+// with the signed JWT claims.
+type claimHeadersReport struct {
+	Consistent bool               `json:"consistent"`
+	Checks     []claimHeaderCheck `json:"checks"`
+}
+
+// checkClaimHeaders compares the claim headers of the request with the claims
+// of the verified JWT. Claims missing a header don't make the report
+// inconsistent, since Pomerium only passes the claims it is configured to.
+func checkClaimHeaders(r *http.Request, rawJWT string) (*claimHeadersReport, error) {
+	claims, err := getJWTClaims(rawJWT)
+	if err != nil {
+		return nil, err
+	}
+
+	// claim names are compared case-insensitively, with "-" and "_" treated
+	// the same, since header names are canonicalized
+	normalize := func(name string) string {
+		return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+	}
+	claimNames := map[string]string{}
+	for name := range claims {
+		claimNames[normalize(name)] = name
+	}
+
+	report := &claimHeadersReport{Consistent: true, Checks: []claimHeaderCheck{}}
+	seen := map[string]bool{}
+	for header, values := range r.Header {
+		lower := strings.ToLower(header)
+		if !strings.HasPrefix(lower, claimHeaderPrefix) {
+			continue
+		}
+
+		check := claimHeaderCheck{
+			Header:      header,
+			Claim:       strings.TrimPrefix(lower, claimHeaderPrefix),
+			HeaderValue: strings.Join(values, ","),
+		}
+		if name, ok := claimNames[normalize(check.Claim)]; ok {
+			seen[name] = true
+			check.Claim = name
+			check.ClaimValue = formatClaimHeaderValue(claims[name])
+			if check.HeaderValue == check.ClaimValue {
+				check.Status = claimHeaderStatusMatch
+			} else {
+				check.Status = claimHeaderStatusMismatch
+				report.Consistent = false
+			}
+		} else {
+			check.Status = claimHeaderStatusMissingClaim
+			report.Consistent = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	for name, value := range claims {
+		if seen[name] || registeredClaims[name] {
+			continue
+		}
+		report.Checks = append(report.Checks, claimHeaderCheck{
+			Claim:      name,
+			Status:     claimHeaderStatusMissingHeader,
+			ClaimValue: formatClaimHeaderValue(value),
+		})
+	}
+
+	sort.Slice(report.Checks, func(i, j int) bool {
+		return report.Checks[i].Claim < report.Checks[j].Claim
+	})
+	return report, nil
+}
+
+// getJWTClaims returns the claims of a JWT without verifying it. Numbers are
+// kept as json.Number so they format the same way as in the JWT.
+func getJWTClaims(rawJWT string) (map[string]any, error) {
+	sig, err := jose.ParseSigned(rawJWT)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(sig.UnsafePayloadWithoutVerification()))
+	dec.UseNumber()
+	var claims map[string]any
+	err = dec.Decode(&claims)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// formatClaimHeaderValue formats a claim the way Pomerium does when passing it
+// as a header: arrays are joined with commas.
+func formatClaimHeaderValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []any:
+		strs := make([]string, len(v))
+		for i, e := range v {
+			strs[i] = formatClaimHeaderValue(e)
+		}
+		return strings.Join(strs, ",")
+	case nil:
+		return ""
+	case json.Number, bool:
+		return fmt.Sprint(v)
+	default:
+		bs, _ := json.Marshal(v)
+		return string(bs)
+	}
+}
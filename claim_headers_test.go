@@ -0,0 +1,54 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckClaimHeaders(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	jwksSrv := newTestJWKSServer(t, signer.JWK())
+	srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL))
+
+	rawJWT := signer.Sign(t, map[string]any{
+		"iss":         "authenticate.example.com",
+		"exp":         time.Now().Add(time.Minute).Unix(),
+		"email":       "user@example.com",
+		"groups":      []string{"admins", "users"},
+		"given_name":  "User",
+		"user":        "user-1",
+		"session_ttl": 3600,
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/verify-info", nil)
+	r.Header.Set("X-Pomerium-Jwt-Assertion", rawJWT)
+	r.Header.Set("X-Pomerium-Claim-Email", "user@example.com")
+	r.Header.Set("X-Pomerium-Claim-Groups", "admins,users")
+	r.Header.Set("X-Pomerium-Claim-Given-Name", "User")
+	r.Header.Set("X-Pomerium-Claim-User", "user-2")
+	r.Header.Set("X-Pomerium-Claim-Role", "admin")
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var res struct {
+		ClaimHeaders *claimHeadersReport `json:"claimHeaders"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.NotNil(t, res.ClaimHeaders)
+	assert.False(t, res.ClaimHeaders.Consistent)
+	assert.Equal(t, []claimHeaderCheck{
+		{Header: "X-Pomerium-Claim-Email", Claim: "email", Status: claimHeaderStatusMatch, HeaderValue: "user@example.com", ClaimValue: "user@example.com"},
+		{Header: "X-Pomerium-Claim-Given-Name", Claim: "given_name", Status: claimHeaderStatusMatch, HeaderValue: "User", ClaimValue: "User"},
+		{Header: "X-Pomerium-Claim-Groups", Claim: "groups", Status: claimHeaderStatusMatch, HeaderValue: "admins,users", ClaimValue: "admins,users"},
+		{Header: "X-Pomerium-Claim-Role", Claim: "role", Status: claimHeaderStatusMissingClaim, HeaderValue: "admin"},
+		{Claim: "session_ttl", Status: claimHeaderStatusMissingHeader, ClaimValue: "3600"},
+		{Header: "X-Pomerium-Claim-User", Claim: "user", Status: claimHeaderStatusMismatch, HeaderValue: "user-2", ClaimValue: "user-1"},
+	}, res.ClaimHeaders.Checks)
+}
@@ -0,0 +1,62 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+// A jwtClaimsReport is the full claim set of a JWT, including claims that
+// sdk.Identity doesn't model.
+type jwtClaimsReport struct {
+	// Verified is false if the claims come from a JWT that failed
+	// verification.
+	Verified bool                       `json:"verified"`
+	Claims   map[string]json.RawMessage `json:"claims"`
+	// Types maps each claim to its JSON type: string, number, boolean, array,
+	// object or null.
+	Types map[string]string `json:"types"`
+}
+
+// getJWTClaimsReport returns the claims of a JWT without verifying it.
+func getJWTClaimsReport(rawJWT string, verified bool) (*jwtClaimsReport, error) {
+	sig, err := jose.ParseSigned(rawJWT)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &jwtClaimsReport{
+		Verified: verified,
+		Types:    make(map[string]string),
+	}
+	err = json.Unmarshal(sig.UnsafePayloadWithoutVerification(), &report.Claims)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range report.Claims {
+		report.Types[name] = getJSONType(value)
+	}
+	return report, nil
+}
+
+func getJSONType(value json.RawMessage) string {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return "null"
+	}
+	switch value[0] {
+	case '"':
+		return "string"
+	case '[':
+		return "array"
+	case '{':
+		return "object"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
@@ -0,0 +1,67 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTClaimsReport(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	jwksSrv := newTestJWKSServer(t, signer.JWK())
+
+	claims := map[string]any{
+		"iss":         "authenticate.example.com",
+		"exp":         time.Now().Add(time.Minute).Unix(),
+		"email":       "user@example.com",
+		"department":  "engineering",
+		"cost_center": 4200,
+		"groups":      []any{"admins", map[string]any{"id": "g1", "name": "users"}},
+		"address":     map[string]any{"country": "US"},
+		"mfa":         true,
+		"nickname":    nil,
+	}
+
+	for _, tc := range []struct {
+		name     string
+		options  []Option
+		verified bool
+	}{
+		{"verified", nil, true},
+		{"unverified", []Option{WithExpectedJWTAudience("other.example.com")}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, append([]Option{WithJWKSEndpoint(jwksSrv.URL)}, tc.options...)...)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/verify-info", nil)
+			r.Header.Set(headerJWTAssertion, signer.Sign(t, claims))
+			w := httptest.NewRecorder()
+			srv.router.ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var res struct {
+				Claims jwtClaimsReport `json:"claims"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, tc.verified, res.Claims.Verified)
+			assert.Equal(t, map[string]string{
+				"iss":         "string",
+				"exp":         "number",
+				"email":       "string",
+				"department":  "string",
+				"cost_center": "number",
+				"groups":      "array",
+				"address":     "object",
+				"mfa":         "boolean",
+				"nickname":    "null",
+			}, res.Claims.Types)
+			assert.JSONEq(t, `["admins",{"id":"g1","name":"users"}]`, string(res.Claims.Claims["groups"]))
+			assert.JSONEq(t, `4200`, string(res.Claims.Claims["cost_center"]))
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package verify
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// A tlsClientCertReport describes whether a server asked for a client
+// certificate and what happened to ours.
+type tlsClientCertReport struct {
+	Requested bool `json:"requested"`
+	Sent      bool `json:"sent"`
+	// Subject and NotAfter describe the certificate sent.
+	Subject  string     `json:"subject,omitempty"`
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	// Accepted is nil until the server has accepted or rejected the
+	// certificate. With TLS 1.3 this is only known after the first read.
+	Accepted *bool  `json:"accepted,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// getClientCertificate returns a tls.Config.GetClientCertificate callback that
+// presents the client certificate, if any, and records the request in the
+// report.
+func (c *keyPair) getClientCertificate(report *tlsClientCertReport) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		report.Requested = true
+		if c == nil {
+			return &tls.Certificate{}, nil
+		}
+
+		cert, leaf := c.get()
+		if cert == nil {
+			report.Error = "no client certificate loaded"
+			return &tls.Certificate{}, nil
+		}
+		report.Sent = true
+		report.Subject = leaf.Subject.String()
+		notAfter := leaf.NotAfter
+		report.NotAfter = &notAfter
+		return cert, nil
+	}
+}
+
+// setAccepted records whether the server accepted our client certificate,
+// based on the error from the handshake or the first read.
+func (report *tlsClientCertReport) setAccepted(err error) {
+	if !report.Sent {
+		return
+	}
+
+	accepted := err == nil
+	report.Accepted = &accepted
+	if err != nil {
+		report.Error = err.Error()
+	}
+}
+
+// isTLSAlert returns true if the error is a TLS alert sent by the server.
+func isTLSAlert(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "remote error"
+}
+
+// A clientCertConn reports whether the server accepted our client certificate
+// on the first read. With TLS 1.3 the server sends its verdict after the
+// handshake has completed. It embeds the *tls.Conn so that its
+// ConnectionState is still available to the HTTP transport.
+type clientCertConn struct {
+	*tls.Conn
+	once sync.Once
+	done func(err error)
+}
+
+func (c *clientCertConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	switch {
+	case err == nil || n > 0:
+		c.once.Do(func() { c.done(nil) })
+	case isTLSAlert(err):
+		c.once.Do(func() { c.done(err) })
+	}
+	return n, err
+}
@@ -0,0 +1,109 @@
+package verify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCertificate(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	rawJWT := signer.Sign(t, jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+
+	ca := newTestCertificate(t, nil, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Client CA"},
+		IsCA:    true,
+	})
+	serverCert := newTestCertificate(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	newClientCert := func(parent *testCA, commonName string) *testCA {
+		return newTestCertificate(t, parent, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: commonName},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+	}
+	otherCA := newTestCertificate(t, nil, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Other CA"},
+		IsCA:    true,
+	})
+
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		t.Run(tls.VersionName(version), func(t *testing.T) {
+			clientCAs := x509.NewCertPool()
+			clientCAs.AddCert(ca.cert)
+			jwksSrv := httptest.NewUnstartedServer(newTestJWKSHandler(signer.JWK()))
+			jwksSrv.TLS = &tls.Config{
+				Certificates: []tls.Certificate{{
+					Certificate: [][]byte{serverCert.cert.Raw},
+					PrivateKey:  serverCert.key,
+				}},
+				ClientAuth: tls.RequireAndVerifyClientCert,
+				ClientCAs:  clientCAs,
+				MaxVersion: version,
+			}
+			jwksSrv.StartTLS()
+			t.Cleanup(jwksSrv.Close)
+
+			verify := func(t *testing.T, options ...Option) (*tlsReport, error) {
+				t.Helper()
+
+				srv := newTestServer(t, append([]Option{WithJWKSEndpoint(jwksSrv.URL)}, options...)...)
+				_, err := verifyTestJWT(t, srv, rawJWT)
+				report := srv.defaultVerifier.tlsVerifier.GetTLSReport("127.0.0.1")
+				require.NotNil(t, report)
+				require.NotNil(t, report.ClientCertificate)
+				return report, err
+			}
+
+			t.Run("accepted", func(t *testing.T) {
+				certPath, keyPath := writeTestKeyPair(t, t.TempDir(), newClientCert(ca, "verify"))
+				report, err := verify(t, WithClientCertificate(certPath, keyPath))
+				require.NoError(t, err)
+				assert.True(t, report.ClientCertificate.Requested)
+				assert.True(t, report.ClientCertificate.Sent)
+				assert.Equal(t, "CN=verify", report.ClientCertificate.Subject)
+				require.NotNil(t, report.ClientCertificate.Accepted)
+				assert.True(t, *report.ClientCertificate.Accepted)
+			})
+			t.Run("connection state", func(t *testing.T) {
+				certPath, keyPath := writeTestKeyPair(t, t.TempDir(), newClientCert(ca, "verify"))
+				srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL), WithClientCertificate(certPath, keyPath))
+				res, err := srv.defaultVerifier.client.Get(jwksSrv.URL)
+				require.NoError(t, err)
+				_ = res.Body.Close()
+				require.NotNil(t, res.TLS, "the TLS connection state should be available to the transport")
+				assert.Equal(t, version, res.TLS.Version)
+			})
+			t.Run("rejected", func(t *testing.T) {
+				certPath, keyPath := writeTestKeyPair(t, t.TempDir(), newClientCert(otherCA, "intruder"))
+				report, err := verify(t, WithClientCertificate(certPath, keyPath))
+				require.Error(t, err)
+				assert.True(t, isTLSError(err))
+				assert.True(t, report.ClientCertificate.Sent)
+				require.NotNil(t, report.ClientCertificate.Accepted)
+				assert.False(t, *report.ClientCertificate.Accepted)
+				assert.NotEmpty(t, report.ClientCertificate.Error)
+			})
+			t.Run("not configured", func(t *testing.T) {
+				report, err := verify(t)
+				require.Error(t, err)
+				assert.True(t, report.ClientCertificate.Requested)
+				assert.False(t, report.ClientCertificate.Sent)
+				assert.Nil(t, report.ClientCertificate.Accepted)
+			})
+		})
+	}
+}
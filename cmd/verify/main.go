@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
@@ -12,6 +18,30 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		os.Exit(runToken(os.Args[2:]))
+	}
+
+	options, err := getOptions()
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+	srv := verify.New(options...)
+	err = srv.Run(context.Background())
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// getOptions returns the options configured in the environment.
+func getOptions() ([]verify.Option, error) {
 	addr := verify.DefaultBindAddress
 	if v, ok := os.LookupEnv("ADDR"); ok {
 		addr = v
@@ -34,20 +64,181 @@ func main() {
 		var err error
 		extraCaCerts, err = csv.NewReader(strings.NewReader(v)).Read()
 		if err != nil {
-			log.Fatal().Err(err).Msg("failed to parse $EXTRA_CA_CERTS (expected comma-separated list of file paths)")
+			return nil, fmt.Errorf("failed to parse $EXTRA_CA_CERTS (expected comma-separated list of file paths): %w", err)
 		}
 	}
 
-	srv := verify.New(
+	options := []verify.Option{
 		verify.WithBindAddress(addr),
 		verify.WithFirestoreProjectID(firestoreProjectID),
 		verify.WithJWKSEndpoint(jwksEndpoint),
 		verify.WithExpectedJWTIssuer(os.Getenv("EXPECTED_JWT_ISSUER")),
 		verify.WithExpectedJWTAudience(os.Getenv("EXPECTED_JWT_AUDIENCE")),
 		verify.WithExtraCACerts(extraCaCerts...),
-	)
-	err := srv.Run(context.Background())
-	if err != nil {
-		log.Fatal().Err(err).Send()
 	}
+
+	tlsCertFile, tlsKeyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if tlsCertFile != "" || tlsKeyFile != "" {
+		if tlsCertFile == "" || tlsKeyFile == "" {
+			return nil, errors.New("$TLS_CERT_FILE and $TLS_KEY_FILE must be set together")
+		}
+		options = append(options, verify.WithTLSCertificate(tlsCertFile, tlsKeyFile))
+	}
+	if v, ok := os.LookupEnv("HTTP_REDIRECT_ADDR"); ok {
+		options = append(options, verify.WithHTTPRedirectAddress(v))
+	}
+	if v, ok := os.LookupEnv("TLS_MIN_VERSION"); ok {
+		version, ok := tlsVersions[v]
+		if !ok {
+			return nil, fmt.Errorf("failed to parse $TLS_MIN_VERSION %q (expected 1.0, 1.1, 1.2 or 1.3)", v)
+		}
+		options = append(options, verify.WithMinTLSVersion(version))
+	}
+
+	if v, ok := os.LookupEnv("TLS_CLIENT_AUTH"); ok {
+		options = append(options, verify.WithTLSClientAuth(v))
+	}
+	if v, ok := os.LookupEnv("TLS_CLIENT_CA_CERTS"); ok {
+		clientCACerts, err := csv.NewReader(strings.NewReader(v)).Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $TLS_CLIENT_CA_CERTS (expected comma-separated list of file paths): %w", err)
+		}
+		options = append(options, verify.WithTLSClientCACerts(clientCACerts...))
+	}
+
+	if v, ok := os.LookupEnv("JWKS_FILE"); ok {
+		options = append(options, verify.WithJWKSFile(v))
+	}
+	if v, ok := os.LookupEnv("JWKS_DATA"); ok {
+		options = append(options, verify.WithJWKSData(v))
+	}
+
+	if v, ok := os.LookupEnv("STRICT_TLS"); ok {
+		strict, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $STRICT_TLS (expected true or false): %w", err)
+		}
+		options = append(options, verify.WithStrictTLS(strict))
+	}
+
+	if v, ok := os.LookupEnv("REVOCATION_CHECK"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $REVOCATION_CHECK (expected true or false): %w", err)
+		}
+		options = append(options, verify.WithRevocationCheck(enabled))
+	}
+	if v, ok := os.LookupEnv("CRL_FILES"); ok {
+		crlFiles, err := csv.NewReader(strings.NewReader(v)).Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $CRL_FILES (expected comma-separated list of file paths): %w", err)
+		}
+		options = append(options, verify.WithCRLFile(crlFiles...))
+	}
+
+	if v, ok := os.LookupEnv("SPKI_PINS"); ok {
+		var spkiPins map[string][]string
+		err := json.Unmarshal([]byte(v), &spkiPins)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $SPKI_PINS (expected JSON object of hosts to lists of pins): %w", err)
+		}
+		for host, pins := range spkiPins {
+			options = append(options, verify.WithSPKIPin(host, pins...))
+		}
+	}
+
+	clientCertFile, clientKeyFile := os.Getenv("CLIENT_CERT_FILE"), os.Getenv("CLIENT_KEY_FILE")
+	if clientCertFile != "" || clientKeyFile != "" {
+		if clientCertFile == "" || clientKeyFile == "" {
+			return nil, errors.New("$CLIENT_CERT_FILE and $CLIENT_KEY_FILE must be set together")
+		}
+		options = append(options, verify.WithClientCertificate(clientCertFile, clientKeyFile))
+	}
+
+	if v, ok := os.LookupEnv("CERT_EXPIRY_WARNING"); ok {
+		threshold, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $CERT_EXPIRY_WARNING (expected duration, e.g. 504h): %w", err)
+		}
+		options = append(options, verify.WithCertExpiryWarning(threshold))
+	}
+
+	if v, ok := os.LookupEnv("OIDC_DISCOVERY"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $OIDC_DISCOVERY (expected true or false): %w", err)
+		}
+		options = append(options, verify.WithOIDCDiscovery(enabled))
+	}
+	if v, ok := os.LookupEnv("OIDC_DISCOVERY_URL"); ok {
+		options = append(options, verify.WithOIDCDiscoveryURL(v))
+	}
+
+	if v, ok := os.LookupEnv("JWKS_CACHE_TTL"); ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $JWKS_CACHE_TTL (expected duration, e.g. 15m): %w", err)
+		}
+		options = append(options, verify.WithJWKSCacheTTL(ttl))
+	}
+
+	if v, ok := os.LookupEnv("JWT_LEEWAY"); ok {
+		leeway, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $JWT_LEEWAY (expected duration, e.g. 30s): %w", err)
+		}
+		options = append(options, verify.WithJWTLeeway(leeway))
+	}
+
+	if v, ok := os.LookupEnv("ALLOWED_SIGNING_ALGORITHMS"); ok {
+		algs, err := csv.NewReader(strings.NewReader(v)).Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $ALLOWED_SIGNING_ALGORITHMS (expected comma-separated list of algorithms): %w", err)
+		}
+		options = append(options, verify.WithAllowedSigningAlgorithms(algs...))
+	}
+
+	if v, ok := os.LookupEnv("TOKEN_SOURCES"); ok {
+		tokenSources, err := csv.NewReader(strings.NewReader(v)).Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $TOKEN_SOURCES (expected comma-separated list of token sources): %w", err)
+		}
+		options = append(options, verify.WithTokenSource(tokenSources...))
+	}
+
+	if v, ok := os.LookupEnv("PROBE_HOSTS"); ok {
+		probeHosts, err := csv.NewReader(strings.NewReader(v)).Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $PROBE_HOSTS (expected comma-separated list of hosts): %w", err)
+		}
+		options = append(options, verify.WithProbeHost(probeHosts...))
+	}
+
+	if v, ok := os.LookupEnv("ADMIN_TOKEN"); ok {
+		options = append(options, verify.WithAdminToken(v))
+	}
+
+	if v, ok := os.LookupEnv("WEBAUTHN_ORIGIN"); ok {
+		options = append(options, verify.WithWebAuthnOrigin(v))
+	}
+
+	if v, ok := os.LookupEnv("TRUSTED_ISSUERS"); ok {
+		var trustedIssuers []struct {
+			Issuer       string   `json:"issuer"`
+			JWKSEndpoint string   `json:"jwks_endpoint"`
+			CACerts      []string `json:"ca_certs"`
+		}
+		err := json.Unmarshal([]byte(v), &trustedIssuers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse $TRUSTED_ISSUERS (expected JSON list of issuers): %w", err)
+		}
+		for _, ti := range trustedIssuers {
+			if ti.Issuer == "" {
+				return nil, errors.New("failed to parse $TRUSTED_ISSUERS (issuer is required)")
+			}
+			options = append(options, verify.WithTrustedIssuer(ti.Issuer, ti.JWKSEndpoint, ti.CACerts...))
+		}
+	}
+
+	return options, nil
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/pomerium/verify"
+)
+
+const tokenUsage = `Usage: verify token [flags] [JWT]
+
+Verifies a Pomerium JWT assertion without starting the server. The JWT is read
+from the first argument, or from stdin if it is missing or "-". The environment
+variables used to configure the server are also used here.
+
+Flags:
+`
+
+// runToken runs the token subcommand and returns the exit status: 0 if the
+// token is valid, 1 if it is invalid and 2 on usage or configuration errors.
+func runToken(args []string) int {
+	flags := flag.NewFlagSet("token", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), tokenUsage)
+		flags.PrintDefaults()
+	}
+	jwks := flags.String("jwks", "", "JWKS URL, JWKS file or PEM public key file (defaults to $JWKS_FILE, $JWKS_DATA, $JWKS_ENDPOINT or the issuer's JWKS endpoint)")
+	issuer := flags.String("issuer", os.Getenv("EXPECTED_JWT_ISSUER"), "expected issuer claim (iss)")
+	audience := flags.String("audience", os.Getenv("EXPECTED_JWT_AUDIENCE"), "expected audience claim (aud)")
+	format := flags.String("format", "human", "output format: human or json")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *format != "human" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "invalid format: %s\n", *format)
+		return 2
+	}
+
+	var rawJWT string
+	switch flags.NArg() {
+	case 0:
+		rawJWT, err = readStdin()
+	case 1:
+		rawJWT = flags.Arg(0)
+		if rawJWT == "-" {
+			rawJWT, err = readStdin()
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read JWT: %v\n", err)
+		return 2
+	}
+
+	// only log problems, the report is the output
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	options, err := getOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	options = append(options,
+		verify.WithExpectedJWTIssuer(*issuer),
+		verify.WithExpectedJWTAudience(*audience),
+	)
+	report, err := verify.CheckToken(context.Background(), rawJWT, *jwks, options...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	} else {
+		writeTokenReport(os.Stdout, report)
+	}
+
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
+
+func readStdin() (string, error) {
+	bs, err := io.ReadAll(io.LimitReader(os.Stdin, 1024*1024))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+func writeTokenReport(w io.Writer, report *verify.TokenReport) {
+	writeTokenReportMap(w, "Header", report.Header)
+	writeTokenReportMap(w, "Claims", report.Claims)
+
+	fmt.Fprintln(w, "Signature:")
+	fmt.Fprintf(w, "  result: %s\n", report.Signature)
+	if report.KeySource != "" {
+		fmt.Fprintf(w, "  keys: %s\n", report.KeySource)
+	}
+	if report.KeyID != "" {
+		fmt.Fprintf(w, "  kid: %s\n", report.KeyID)
+	}
+
+	fmt.Fprintln(w, "Time:")
+	fmt.Fprintf(w, "  now: %s\n", report.Time.Now.UTC().Format(timeFormat))
+	if report.Time.IssuedAt != nil {
+		fmt.Fprintf(w, "  iat: %s\n", report.Time.IssuedAt.UTC().Format(timeFormat))
+	}
+	if report.Time.NotBefore != nil {
+		fmt.Fprintf(w, "  nbf: %s", report.Time.NotBefore.UTC().Format(timeFormat))
+		if report.Time.NotYetValid {
+			fmt.Fprint(w, " (not valid yet)")
+		}
+		fmt.Fprintln(w)
+	}
+	if report.Time.Expiry != nil {
+		fmt.Fprintf(w, "  exp: %s", report.Time.Expiry.UTC().Format(timeFormat))
+		if report.Time.Expired {
+			fmt.Fprintf(w, " (expired %s ago)\n", strings.TrimPrefix(report.Time.ExpiresIn, "-"))
+		} else {
+			fmt.Fprintf(w, " (expires in %s)\n", report.Time.ExpiresIn)
+		}
+	}
+	if report.Time.Skew != "" {
+		fmt.Fprintf(w, "  skew: %s (time since iat)\n", report.Time.Skew)
+	}
+	fmt.Fprintf(w, "  leeway: %s\n", report.Time.Leeway)
+	fmt.Fprintf(w, "  valid with leeway: %t, without leeway: %t\n",
+		report.Time.ValidWithLeeway, report.Time.ValidWithoutLeeway)
+
+	if report.Valid {
+		fmt.Fprintln(w, "Result: valid")
+		return
+	}
+	fmt.Fprintf(w, "Result: invalid (%s)\n", report.Code)
+	fmt.Fprintf(w, "  error: %s\n", report.Error)
+	fmt.Fprintf(w, "  hint: %s\n", report.Hint)
+}
+
+const timeFormat = "2006-01-02T15:04:05Z"
+
+func writeTokenReportMap(w io.Writer, title string, m map[string]any) {
+	if len(m) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, k := range keys {
+		v, ok := m[k].(string)
+		if !ok {
+			bs, _ := json.Marshal(m[k])
+			v = string(bs)
+		}
+		fmt.Fprintf(w, "  %s: %s\n", k, v)
+	}
+}
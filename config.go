@@ -1,21 +1,67 @@
 package verify
 
-import "cloud.google.com/go/firestore"
+import (
+	"crypto/tls"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
 
 // config defaults
 var (
 	DefaultBindAddress  = ":8000"
 	DefaultJWKSEndpoint = "" // use the audience
 	DefaultProjectID    = firestore.DetectProjectID
+	DefaultJWTLeeway    = jwt.DefaultLeeway
+	// DefaultCertExpiryWarning is how long before a certificate expires a
+	// warning is reported.
+	DefaultCertExpiryWarning = 21 * 24 * time.Hour
+	// DefaultMinTLSVersion is the minimum TLS version accepted when serving
+	// HTTPS.
+	DefaultMinTLSVersion uint16 = tls.VersionTLS12
 )
 
 type config struct {
 	bindAddress         string
+	tlsCertFile         string
+	tlsKeyFile          string
+	httpRedirectAddress string
+	minTLSVersion       uint16
+	tlsClientAuth       string
+	tlsClientCACerts    []string
 	firestoreProjectID  string
 	jwksEndpoint        string
+	jwksFile            string
+	jwksData            string
+	oidcDiscovery       bool
+	oidcDiscoveryURL    string
 	expectedJWTIssuer   string
 	expectedJWTAudience string
 	extraCACerts        []string
+	strictTLS           bool
+	certExpiryWarning   time.Duration
+	revocationCheck     bool
+	crlFiles            []string
+	spkiPins            map[string][]string
+	clientCertFile      string
+	clientKeyFile       string
+	probeHosts          []string
+	trustedIssuers      []trustedIssuer
+	tokenSources        []string
+	webAuthnOrigin      string
+	adminToken          string
+
+	allowedSigningAlgorithms []string
+	jwksCacheTTL             time.Duration
+	jwtLeeway                time.Duration
+}
+
+type trustedIssuer struct {
+	issuer       string
+	jwksEndpoint string
+	caCerts      []string
 }
 
 // An Option customizes the config.
@@ -28,6 +74,51 @@ func WithBindAddress(bindAddress string) Option {
 	}
 }
 
+// WithTLSCertificate sets the paths to a PEM encoded certificate and key in
+// the config. When set, verify serves HTTPS on the bind address instead of
+// HTTP. The files are reloaded when they change.
+func WithTLSCertificate(certFile, keyFile string) Option {
+	return func(cfg *config) {
+		cfg.tlsCertFile = certFile
+		cfg.tlsKeyFile = keyFile
+	}
+}
+
+// WithHTTPRedirectAddress sets an address in the config on which plain HTTP
+// requests are redirected to HTTPS. It is only used with a TLS certificate.
+func WithHTTPRedirectAddress(addr string) Option {
+	return func(cfg *config) {
+		cfg.httpRedirectAddress = addr
+	}
+}
+
+// WithMinTLSVersion sets the minimum TLS version accepted when serving HTTPS
+// in the config, e.g. tls.VersionTLS13.
+func WithMinTLSVersion(version uint16) Option {
+	return func(cfg *config) {
+		cfg.minTLSVersion = version
+	}
+}
+
+// WithTLSClientAuth sets whether client certificates are requested when
+// serving HTTPS in the config: "request" to ask for one, or "require" to
+// reject connections without a certificate signed by one of the client CA
+// certs. Requires WithTLSCertificate and WithTLSClientCACerts.
+func WithTLSClientAuth(mode string) Option {
+	return func(cfg *config) {
+		cfg.tlsClientAuth = mode
+	}
+}
+
+// WithTLSClientCACerts adds paths to the CA certificates client certificates
+// are validated against to the config. The files are reloaded when they
+// change.
+func WithTLSClientCACerts(paths ...string) Option {
+	return func(cfg *config) {
+		cfg.tlsClientCACerts = append(cfg.tlsClientCACerts, paths...)
+	}
+}
+
 // WithJWKSEndpoint sets the jwks endpoint in the config.
 func WithJWKSEndpoint(jwksEndpoint string) Option {
 	return func(cfg *config) {
@@ -35,8 +126,57 @@ func WithJWKSEndpoint(jwksEndpoint string) Option {
 	}
 }
 
+// WithJWKSFile sets the path to a file containing the JWT signing keys in the
+// config, either as a JSON Web Key Set or as PEM encoded public keys. The file
+// is reloaded when it changes. When set, keys are not fetched from the JWKS
+// endpoint.
+func WithJWKSFile(path string) Option {
+	return func(cfg *config) {
+		cfg.jwksFile = path
+	}
+}
+
+// WithJWKSData sets the JWT signing keys in the config, either as a JSON Web
+// Key Set or as PEM encoded public keys. When set, keys are not fetched from
+// the JWKS endpoint.
+func WithJWKSData(data string) Option {
+	return func(cfg *config) {
+		cfg.jwksData = data
+	}
+}
+
+// WithOIDCDiscovery enables resolving the JWKS endpoint from the issuer's
+// /.well-known/openid-configuration document in the config. It is only used
+// when no JWKS endpoint, file or data is set, and requires an expected JWT
+// issuer or trusted issuers, the only issuers discovery is done for.
+func WithOIDCDiscovery(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.oidcDiscovery = enabled
+	}
+}
+
+// WithOIDCDiscoveryURL enables OIDC discovery using the given discovery
+// document URL instead of the one derived from the expected JWT issuer.
+// Trusted issuers always use their own discovery URL.
+func WithOIDCDiscoveryURL(discoveryURL string) Option {
+	return func(cfg *config) {
+		cfg.oidcDiscovery = discoveryURL != "" || cfg.oidcDiscovery
+		cfg.oidcDiscoveryURL = discoveryURL
+	}
+}
+
+// WithJWKSCacheTTL sets how long keys fetched from a JWKS endpoint are cached
+// in the config. If 0, the TTL is taken from the endpoint's Cache-Control
+// header, defaulting to an hour.
+func WithJWKSCacheTTL(ttl time.Duration) Option {
+	return func(cfg *config) {
+		cfg.jwksCacheTTL = ttl
+	}
+}
+
 // WithExpectedJWTIssuer sets the expected JWT issuer claim in the config. If
-// set to the empty string, the issuer claim will not be validated.
+// set to the empty string, the issuer claim will not be validated. It can't be
+// combined with WithTrustedIssuer.
 func WithExpectedJWTIssuer(issuer string) Option {
 	return func(cfg *config) {
 		cfg.expectedJWTIssuer = issuer
@@ -51,6 +191,15 @@ func WithExpectedJWTAudience(audience string) Option {
 	}
 }
 
+// WithJWTLeeway sets the leeway allowed when validating the time-based JWT
+// claims (exp, nbf and iat) in the config, to tolerate clock skew between
+// Pomerium and verify.
+func WithJWTLeeway(leeway time.Duration) Option {
+	return func(cfg *config) {
+		cfg.jwtLeeway = leeway
+	}
+}
+
 // WithFirestoreProjectID sets the firestore project id in the config.
 func WithFirestoreProjectID(projectID string) Option {
 	return func(cfg *config) {
@@ -60,19 +209,150 @@ func WithFirestoreProjectID(projectID string) Option {
 
 // WithExtraCACerts adds paths to custom CA certificates to the config.
 // Certificates added with this option will be used in addition to the system
-// default pool.
+// default pool. The files are reloaded when they change.
 func WithExtraCACerts(paths ...string) Option {
 	return func(cfg *config) {
 		cfg.extraCACerts = append(cfg.extraCACerts, paths...)
 	}
 }
 
+// WithStrictTLS sets whether TLS connections used to fetch keys fail when the
+// server's certificate is invalid. Otherwise the error is only reported.
+func WithStrictTLS(strict bool) Option {
+	return func(cfg *config) {
+		cfg.strictTLS = strict
+	}
+}
+
+// WithCertExpiryWarning sets how long before a TLS certificate expires it is
+// reported as expiring. Zero disables the warning.
+func WithCertExpiryWarning(threshold time.Duration) Option {
+	return func(cfg *config) {
+		cfg.certExpiryWarning = threshold
+	}
+}
+
+// WithRevocationCheck sets whether the certificates of servers keys are
+// fetched from are checked for revocation.
+func WithRevocationCheck(enabled bool) Option {
+	return func(cfg *config) {
+		cfg.revocationCheck = enabled
+	}
+}
+
+// WithCRLFile adds paths to local certificate revocation lists to the config,
+// and enables revocation checking.
+func WithCRLFile(paths ...string) Option {
+	return func(cfg *config) {
+		cfg.revocationCheck = cfg.revocationCheck || len(paths) > 0
+		cfg.crlFiles = append(cfg.crlFiles, paths...)
+	}
+}
+
+// WithSPKIPin pins the public keys trusted for a JWKS host. Each pin is the
+// base64 encoded SHA-256 hash of a SubjectPublicKeyInfo, optionally prefixed
+// with "sha256/". Once pinned, connections to the host fail unless a
+// certificate in its chain matches one of the pins.
+func WithSPKIPin(host string, pins ...string) Option {
+	return func(cfg *config) {
+		if cfg.spkiPins == nil {
+			cfg.spkiPins = make(map[string][]string)
+		}
+		host = strings.ToLower(host)
+		cfg.spkiPins[host] = append(cfg.spkiPins[host], pins...)
+	}
+}
+
+// WithClientCertificate sets the paths to a PEM encoded client certificate
+// and key presented when a JWKS host asks for one, e.g. behind a load balancer
+// that requires mutual TLS. The files are reloaded when they change.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(cfg *config) {
+		cfg.clientCertFile = certFile
+		cfg.clientKeyFile = keyFile
+	}
+}
+
+// WithProbeHost adds hosts, as host or host:port, that may be probed with
+// /api/probe.
+func WithProbeHost(hosts ...string) Option {
+	return func(cfg *config) {
+		cfg.probeHosts = append(cfg.probeHosts, hosts...)
+	}
+}
+
+// WithTrustedIssuer adds a trusted JWT issuer to the config. Tokens from the
+// issuer are verified using keys fetched from jwksEndpoint, or from the
+// issuer's well-known Pomerium endpoint if it is empty. If any CA certificate
+// paths are given, they replace the system and extra CA certificates when
+// fetching the issuer's keys.
+//
+// Once a trusted issuer has been added, tokens from any other issuer are
+// rejected, so an expected JWT issuer, JWKS endpoint, file or data, or OIDC
+// discovery URL can't be set as well.
+func WithTrustedIssuer(issuer, jwksEndpoint string, caPaths ...string) Option {
+	return func(cfg *config) {
+		cfg.trustedIssuers = append(cfg.trustedIssuers, trustedIssuer{
+			issuer:       issuer,
+			jwksEndpoint: jwksEndpoint,
+			caCerts:      caPaths,
+		})
+	}
+}
+
+// WithTokenSource sets the ordered list of places to look for the JWT
+// assertion in the config. Each source is one of:
+//
+//   - "header:<name>", a request header
+//   - "bearer", an "Authorization: Bearer" header
+//   - "cookie:<name>", a cookie
+//   - "query:<name>", a query parameter
+//
+// By default the X-Pomerium-Jwt-Assertion header is used, followed by the jwt
+// query parameter.
+func WithTokenSource(sources ...string) Option {
+	return func(cfg *config) {
+		cfg.tokenSources = sources
+	}
+}
+
+// WithAllowedSigningAlgorithms sets the JWS algorithms accepted for JWT
+// assertions in the config, e.g. "ES256". If empty, any asymmetric algorithm
+// is accepted. "none" and the HMAC algorithms are always rejected.
+func WithAllowedSigningAlgorithms(algs ...string) Option {
+	return func(cfg *config) {
+		cfg.allowedSigningAlgorithms = algs
+	}
+}
+
+// WithWebAuthnOrigin sets the origin of the WebAuthn relying party in the
+// config, e.g. "https://verify.example.com". If empty, the origin is derived
+// from the request's Host header and X-Forwarded-Proto.
+func WithWebAuthnOrigin(origin string) Option {
+	return func(cfg *config) {
+		cfg.webAuthnOrigin = strings.TrimSuffix(origin, "/")
+	}
+}
+
+// WithAdminToken sets the token in the config that authorizes administrative
+// requests, such as flushing the JWKS cache, in an "Authorization: Bearer"
+// header. If empty, administrative requests are rejected.
+func WithAdminToken(token string) Option {
+	return func(cfg *config) {
+		cfg.adminToken = token
+	}
+}
+
 func getConfig(options ...Option) *config {
 	cfg := new(config)
 	WithBindAddress(DefaultBindAddress)(cfg)
 	// by default the firestore project id is derived from the environment
 	WithFirestoreProjectID(DefaultProjectID)(cfg)
 	WithJWKSEndpoint(DefaultJWKSEndpoint)(cfg)
+	WithTokenSource(defaultTokenSources...)(cfg)
+	WithJWTLeeway(DefaultJWTLeeway)(cfg)
+	WithCertExpiryWarning(DefaultCertExpiryWarning)(cfg)
+	WithMinTLSVersion(DefaultMinTLSVersion)(cfg)
 	for _, option := range options {
 		option(cfg)
 	}
@@ -9,7 +9,6 @@ import (
 	"html/template"
 	"io"
 	"io/fs"
-	stdlog "log"
 	"net/http"
 	"net/url"
 	"os"
@@ -30,38 +29,13 @@ import (
 var uiFS embed.FS
 
 func (srv *Server) initRouter() {
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.DialTLSContext = srv.tlsVerifier.DialTLSContext
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   maxRemoteWait,
-	}
-
-	expected := &jwt.Expected{
-		Issuer: srv.cfg.expectedJWTIssuer,
-	}
-	if aud := srv.cfg.expectedJWTAudience; aud != "" {
-		expected.Audience = jwt.Audience([]string{aud})
-	}
-
-	datastore, err := sdk.NewLRUKeyStore(1024)
-	if err != nil {
-		log.Fatal().Err(err).Send()
-	}
-
-	verifier, err := sdk.New(&sdk.Options{
-		Datastore:    datastore,
-		HTTPClient:   client,
-		Logger:       stdlog.New(log.With().Logger(), "", 0),
-		JWKSEndpoint: srv.cfg.jwksEndpoint,
-		Expected:     expected,
-	})
+	err := srv.initIssuerVerifiers()
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 
 	srv.router = chi.NewRouter()
-	srv.router.Use(sdk.AddIdentityToRequest(verifier))
+	srv.router.Use(srv.addIdentityToRequest)
 
 	srv.router.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -71,9 +45,16 @@ func (srv *Server) initRouter() {
 	srv.router.Route("/api", func(r chi.Router) {
 		r.Use(middleware.NoCache)
 
+		r.Get("/jwks", srv.serveAPIJWKS)
+		r.Post("/jwks/flush", srv.serveAPIJWKSFlush)
+		r.Get("/status", srv.serveAPIStatus)
+		r.Get("/probe", srv.serveAPIProbe)
+		r.Get("/tls-history", srv.serveAPITLSHistory)
 		r.Get("/verify-info", srv.serveAPIVerifyInfo)
-		r.Post("/webauthn-authenticate", srv.serveAPIWebAuthnAuthenticate)
-		r.Post("/webauthn-register", srv.serveAPIWebAuthnRegister)
+		r.Post("/webauthn/authenticate/begin", srv.serveAPIWebAuthnAuthenticateBegin)
+		r.Post("/webauthn/authenticate/finish", srv.serveAPIWebAuthnAuthenticateFinish)
+		r.Post("/webauthn/register/begin", srv.serveAPIWebAuthnRegisterBegin)
+		r.Post("/webauthn/register/finish", srv.serveAPIWebAuthnRegisterFinish)
 
 		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 			http.NotFound(w, r)
@@ -174,37 +155,70 @@ func (srv *Server) serveAPIVerifyInfo(w http.ResponseWriter, r *http.Request) {
 		"headers": getPomeriumHeaders(r),
 	}
 	var tlsErrStr string
-	if identity, err := sdk.FromContext(r.Context()); err == nil {
-		res["identity"] = identity
-		jwksDomain := identity.Issuer
-		if srv.cfg.jwksEndpoint != "" {
-			u, err := url.Parse(srv.cfg.jwksEndpoint)
-			if err == nil {
-				jwksDomain = u.Hostname()
+	rawJWT, source, _ := srv.findRawJWT(r)
+	if header, err := getJWSHeader(rawJWT); err == nil {
+		res["jwtHeader"] = header
+	}
+	if tok, err := jwt.ParseSigned(rawJWT); err == nil {
+		var claims jwt.Claims
+		if tok.UnsafeClaimsWithoutVerification(&claims) == nil {
+			res["time"] = newTokenTimeReport(&claims, time.Now(), srv.cfg.jwtLeeway)
+			if iv, err := srv.getIssuerVerifier(rawJWT); err == nil {
+				res["jwksEndpoint"] = iv.getJWKSEndpointInfo(claims.Issuer)
+				if u, err := url.Parse(iv.getJWKSEndpoint(claims.Issuer)); err == nil {
+					if report := iv.tlsVerifier.GetTLSReport(u.Hostname()); report != nil {
+						res["tls"] = report
+					}
+				}
 			}
 		}
-		if e := srv.tlsVerifier.GetTLSError(jwksDomain); e != nil {
-			tlsErrStr = e.Error()
+	}
+	identity, err := sdk.FromContext(r.Context())
+	if err == nil {
+		res["identity"] = identity
+		if claims, err := getJWTClaimsReport(rawJWT, true); err == nil {
+			res["claims"] = claims
+		}
+		if report, err := checkClaimHeaders(r, rawJWT); err == nil {
+			res["claimHeaders"] = report
+		}
+		if iv, err := srv.getIssuerVerifier(rawJWT); err == nil {
+			if u, err := url.Parse(iv.getJWKSEndpoint(identity.Issuer)); err == nil {
+				if e := iv.tlsVerifier.GetTLSError(u.Hostname()); e != nil {
+					tlsErrStr = e.Error()
+				}
+			}
 		}
 	} else {
-		res["identity"] = getUnverifiedIdentity(r)
+		res["identity"] = getUnverifiedIdentity(rawJWT)
+		if claims, err := getJWTClaimsReport(rawJWT, false); err == nil {
+			res["claims"] = claims
+		}
 		res["error"] = err.Error()
+		res["diagnosis"] = srv.diagnoseJWT(rawJWT, err, time.Now())
+	}
+	if report := srv.getMTLSReport(r); report != nil {
+		res["clientCertificate"] = report
 	}
+	res["caCerts"] = srv.getCACertFiles()
+	res["chain"] = srv.getIdentityChain(r)
+	res["headerWarnings"] = srv.checkIdentityHeaders(r, rawJWT, err == nil)
 	res["request"] = M{
-		"origin":   getOrigin(r),
-		"method":   r.Method,
-		"url":      r.URL.RequestURI(),
-		"host":     r.Host,
-		"hostname": getHostname(),
-		"tlsError": tlsErrStr,
+		"origin":      getOrigin(r),
+		"method":      r.Method,
+		"url":         r.URL.RequestURI(),
+		"host":        r.Host,
+		"hostname":    getHostname(),
+		"tlsError":    tlsErrStr,
+		"tokenSource": source.String(),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(res)
 }
 
-func getUnverifiedIdentity(r *http.Request) *sdk.Identity {
+func getUnverifiedIdentity(rawJWT string) *sdk.Identity {
 	identity := new(sdk.Identity)
-	jwt, err := jose.ParseSigned(sdk.TokenFromHeader(r))
+	jwt, err := jose.ParseSigned(rawJWT)
 	if err != nil {
 		log.Err(err).Msg("error parsing JWT assertion header")
 		return identity
@@ -1,85 +1,306 @@
 package verify
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
 
 	"github.com/pomerium/verify/internal/storage"
 	"github.com/pomerium/webauthn"
+	"github.com/pomerium/webauthn/cose"
 )
 
-const maxBodySize = 4 * 1024 * 1024
+const (
+	maxBodySize = 4 * 1024 * 1024
 
-func (srv *Server) serveAPIWebAuthnAuthenticate(w http.ResponseWriter, r *http.Request) {
-	var req storage.WebAuthnAuthenticateRequest
+	webAuthnChallengeSize   = 32
+	webAuthnUserHandleSize  = 32
+	webAuthnCeremonyTimeout = 5 * time.Minute
+	webAuthnMaxUsernameSize = 64
+
+	// begin requests are unauthenticated and each store a ceremony, so they
+	// are rate limited
+	webAuthnBeginRate  rate.Limit = 10
+	webAuthnBeginBurst            = 50
+)
+
+var (
+	errWebAuthnOriginMismatch = errors.New("origin does not match the relying party origin")
+	errWebAuthnRateLimited    = errors.New("too many webauthn ceremonies, try again later")
+)
+
+type webAuthnAuthenticateBeginRequest struct {
+	AllowCredentials []webauthn.PublicKeyCredentialDescriptor `json:"allowCredentials"`
+}
+
+type webAuthnRegisterBeginRequest struct {
+	Username                string                                   `json:"username"`
+	Attestation             webauthn.AttestationConveyancePreference `json:"attestation"`
+	AuthenticatorAttachment webauthn.AuthenticatorAttachment         `json:"authenticatorAttachment"`
+}
+
+func (srv *Server) serveAPIWebAuthnAuthenticateBegin(w http.ResponseWriter, r *http.Request) {
+	if !srv.allowWebAuthnBegin(w) {
+		return
+	}
+
+	var req webAuthnAuthenticateBeginRequest
 	err := decodeJSONBody(r, &req)
 	if err != nil {
-		log.Error().Err(err).Msg("bad request for webauthn authenticate")
+		log.Error().Err(err).Msg("bad request for webauthn authenticate begin")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	origin, rpID, err := srv.getWebAuthnRelyingParty(r)
+	if errors.Is(err, errWebAuthnOriginMismatch) {
+		log.Error().Err(err).Msg("webauthn: invalid origin")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := newWebAuthnChallenge()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	options := &webauthn.PublicKeyCredentialRequestOptions{
+		Challenge:        challenge,
+		Timeout:          webAuthnCeremonyTimeout,
+		RPID:             rpID,
+		UserVerification: webauthn.UserVerificationPreferred,
+	}
+	for _, c := range req.AllowCredentials {
+		options.AllowCredentials = append(options.AllowCredentials, webauthn.PublicKeyCredentialDescriptor{
+			Type: webauthn.PublicKeyCredentialTypePublicKey,
+			ID:   c.ID,
+		})
+	}
+
+	err = srv.storage.SetAuthenticateCeremony(r.Context(), &storage.WebAuthnAuthenticateCeremony{
+		Origin:    origin,
+		Options:   options,
+		ExpiresAt: time.Now().Add(webAuthnCeremonyTimeout),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(options)
+}
+
+func (srv *Server) serveAPIWebAuthnAuthenticateFinish(w http.ResponseWriter, r *http.Request) {
+	_, _, err := srv.getWebAuthnRelyingParty(r)
+	if errors.Is(err, errWebAuthnOriginMismatch) {
+		log.Error().Err(err).Msg("webauthn: invalid origin")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var credential webauthn.PublicKeyAssertionCredential
+	err = decodeJSONBody(r, &credential)
+	if err != nil {
+		log.Error().Err(err).Msg("bad request for webauthn authenticate finish")
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = srv.storage.SetAuthenticateRequest(r.Context(), &req)
+	clientData, err := credential.Response.UnmarshalClientData()
 	if err != nil {
+		log.Error().Err(err).Msg("bad request for webauthn authenticate finish")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the challenge in the client data is only used to find the ceremony, the
+	// credential is then verified against the options stored on the server
+	ceremony, err := srv.storage.TakeAuthenticateCeremony(r.Context(), clientData.Challenge)
+	if errors.Is(err, storage.ErrCeremonyNotFound) {
+		log.Error().Err(err).Msg("webauthn: invalid authentication ceremony")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	origin := getRPOrigin(r)
-	if clientData, err := req.Credential.Response.UnmarshalClientData(); err == nil {
-		// use the provided origin
-		origin = clientData.Origin
+	// authenticators only return the user handle for discoverable credentials,
+	// otherwise the user is identified by the allowed credential they used
+	if len(credential.Response.UserHandle) == 0 && len(ceremony.Options.AllowCredentials) > 0 {
+		if serverCredential, err := srv.storage.GetCredential(r.Context(), credential.RawID); err == nil {
+			credential.Response.UserHandle = serverCredential.OwnerID
+		}
 	}
 
-	rp := webauthn.NewRelyingParty(origin, srv.storage)
+	err = srv.storage.SetAuthenticateRequest(r.Context(), &storage.WebAuthnAuthenticateRequest{
+		Options:    ceremony.Options,
+		Credential: &credential,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	// This verification is insecure because we trust the options provided by the client. A real implementation
-	// should generate the options (particularly the challenge) on the server.
-	credential, err := rp.VerifyAuthenticationCeremony(r.Context(), req.Options, req.Credential)
+	rp := webauthn.NewRelyingParty(ceremony.Origin, srv.storage)
+	_, err = rp.VerifyAuthenticationCeremony(r.Context(), ceremony.Options, &credential)
 	if err != nil {
 		log.Error().Err(err).Msg("webauthn: invalid authentication ceremony")
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	_ = credential
 }
 
-func (srv *Server) serveAPIWebAuthnRegister(w http.ResponseWriter, r *http.Request) {
-	var req storage.WebAuthnRegisterRequest
+func (srv *Server) serveAPIWebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	if !srv.allowWebAuthnBegin(w) {
+		return
+	}
+
+	var req webAuthnRegisterBeginRequest
 	err := decodeJSONBody(r, &req)
+	if err == nil {
+		err = req.validate()
+	}
 	if err != nil {
-		log.Error().Err(err).Msg("bad request for webauthn register")
+		log.Error().Err(err).Msg("bad request for webauthn register begin")
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = srv.storage.SetRegisterRequest(r.Context(), &req)
+	origin, rpID, err := srv.getWebAuthnRelyingParty(r)
+	if errors.Is(err, errWebAuthnOriginMismatch) {
+		log.Error().Err(err).Msg("webauthn: invalid origin")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := newWebAuthnChallenge()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	origin := getRPOrigin(r)
-	if clientData, err := req.Credential.Response.UnmarshalClientData(); err == nil {
-		// use the provided origin
-		origin = clientData.Origin
+	// the user handle must not contain personally identifying information, so
+	// a random one is used rather than the username
+	userHandle, err := newWebAuthnUserHandle()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	rp := webauthn.NewRelyingParty(origin, srv.storage)
+	options := &webauthn.PublicKeyCredentialCreationOptions{
+		RP: webauthn.PublicKeyCredentialRPEntity{
+			ID:   rpID,
+			Name: "Pomerium",
+		},
+		User: webauthn.PublicKeyCredentialUserEntity{
+			ID:          userHandle,
+			DisplayName: req.Username,
+			Name:        req.Username,
+		},
+		Challenge: challenge,
+		PubKeyCredParams: []webauthn.PublicKeyCredentialParameters{
+			{Type: webauthn.PublicKeyCredentialTypePublicKey, COSEAlgorithmIdentifier: cose.AlgorithmRS256},
+			{Type: webauthn.PublicKeyCredentialTypePublicKey, COSEAlgorithmIdentifier: cose.AlgorithmES256},
+		},
+		Timeout: webAuthnCeremonyTimeout,
+		AuthenticatorSelection: &webauthn.AuthenticatorSelectionCriteria{
+			AuthenticatorAttachment: req.AuthenticatorAttachment,
+			ResidentKey:             webauthn.ResidentKeyPreferred,
+			UserVerification:        webauthn.UserVerificationPreferred,
+		},
+		Attestation: req.Attestation,
+	}
 
-	// This verification is insecure because we trust the options provided by the client. A real implementation
-	// should generate the options (particularly the challenge) on the server.
-	credential, err := rp.VerifyRegistrationCeremony(r.Context(), req.Options, req.Credential)
+	err = srv.storage.SetRegisterCeremony(r.Context(), &storage.WebAuthnRegisterCeremony{
+		Origin:    origin,
+		Options:   options,
+		ExpiresAt: time.Now().Add(webAuthnCeremonyTimeout),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(options)
+}
+
+func (srv *Server) serveAPIWebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	_, _, err := srv.getWebAuthnRelyingParty(r)
+	if errors.Is(err, errWebAuthnOriginMismatch) {
+		log.Error().Err(err).Msg("webauthn: invalid origin")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var credential webauthn.PublicKeyCreationCredential
+	err = decodeJSONBody(r, &credential)
+	if err != nil {
+		log.Error().Err(err).Msg("bad request for webauthn register finish")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientData, err := credential.Response.UnmarshalClientData()
+	if err != nil {
+		log.Error().Err(err).Msg("bad request for webauthn register finish")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the challenge in the client data is only used to find the ceremony, the
+	// credential is then verified against the options stored on the server
+	ceremony, err := srv.storage.TakeRegisterCeremony(r.Context(), clientData.Challenge)
+	if errors.Is(err, storage.ErrCeremonyNotFound) {
+		log.Error().Err(err).Msg("webauthn: invalid registration ceremony")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = srv.storage.SetRegisterRequest(r.Context(), &storage.WebAuthnRegisterRequest{
+		Options:    ceremony.Options,
+		Credential: &credential,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rp := webauthn.NewRelyingParty(ceremony.Origin, srv.storage)
+	serverCredential, err := rp.VerifyRegistrationCeremony(r.Context(), ceremony.Options, &credential)
 	if err != nil {
 		log.Error().Err(err).Msg("webauthn: invalid registration ceremony")
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
 
-	err = srv.storage.SetCredential(r.Context(), credential)
+	err = srv.storage.SetCredential(r.Context(), serverCredential)
 	if err != nil {
 		log.Error().Err(err).Msg("webauthn: invalid registration ceremony")
 		http.Error(w, err.Error(), http.StatusForbidden)
@@ -87,6 +308,65 @@ func (srv *Server) serveAPIWebAuthnRegister(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// allowWebAuthnBegin returns whether a ceremony may be started, or else
+// responds with 429 Too Many Requests.
+func (srv *Server) allowWebAuthnBegin(w http.ResponseWriter) bool {
+	if srv.webAuthnBeginLimiter.Allow() {
+		return true
+	}
+	log.Error().Err(errWebAuthnRateLimited).Msg("webauthn: rate limited")
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, errWebAuthnRateLimited.Error(), http.StatusTooManyRequests)
+	return false
+}
+
+func (req *webAuthnRegisterBeginRequest) validate() error {
+	if req.Username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if len(req.Username) > webAuthnMaxUsernameSize {
+		return fmt.Errorf("username must be at most %d bytes", webAuthnMaxUsernameSize)
+	}
+
+	switch req.Attestation {
+	case "",
+		webauthn.AttestationConveyanceNone,
+		webauthn.AttestationConveyanceIndirect,
+		webauthn.AttestationConveyanceDirect,
+		webauthn.AttestationConveyanceEnterprise:
+	default:
+		return fmt.Errorf("unsupported attestation: %s", req.Attestation)
+	}
+
+	switch req.AuthenticatorAttachment {
+	case "",
+		webauthn.AuthenticatorAttachmentPlatform,
+		webauthn.AuthenticatorAttachmentCrossPlatform:
+	default:
+		return fmt.Errorf("unsupported authenticator attachment: %s", req.AuthenticatorAttachment)
+	}
+
+	return nil
+}
+
+func newWebAuthnChallenge() ([]byte, error) {
+	challenge := make([]byte, webAuthnChallengeSize)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func newWebAuthnUserHandle() ([]byte, error) {
+	userHandle := make([]byte, webAuthnUserHandleSize)
+	_, err := rand.Read(userHandle)
+	if err != nil {
+		return nil, err
+	}
+	return userHandle, nil
+}
+
 func decodeJSONBody(r *http.Request, dsts ...interface{}) error {
 	defer func() { _ = r.Body.Close() }()
 
@@ -105,10 +385,46 @@ func decodeJSONBody(r *http.Request, dsts ...interface{}) error {
 	return nil
 }
 
+// getWebAuthnRelyingParty returns the origin and ID of the WebAuthn relying
+// party: the configured origin, or the origin of the request. The client's
+// Origin header is only compared with it, and requests from any other origin
+// are rejected.
+func (srv *Server) getWebAuthnRelyingParty(r *http.Request) (origin, rpID string, err error) {
+	origin = srv.cfg.webAuthnOrigin
+	if origin == "" {
+		origin = getRPOrigin(r)
+	}
+	if requestOrigin := r.Header.Get("Origin"); requestOrigin != "" && requestOrigin != origin {
+		return "", "", fmt.Errorf("%w: %s != %s", errWebAuthnOriginMismatch, requestOrigin, origin)
+	}
+
+	rpID, err = getRPID(origin)
+	if err != nil {
+		return "", "", err
+	}
+	return origin, rpID, nil
+}
+
+func getRPID(origin string) (string, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("invalid origin: %s", origin)
+	}
+	return u.Hostname(), nil
+}
+
+// getRPOrigin returns the origin of the request. Pomerium terminates TLS, so
+// the scheme is taken from X-Forwarded-Proto when it is set.
 func getRPOrigin(r *http.Request) string {
 	scheme := "https"
 	if r.TLS == nil {
 		scheme = "http"
 	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" || proto == "http" {
+		scheme = proto
+	}
 	return scheme + "://" + r.Host
 }
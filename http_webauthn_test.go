@@ -0,0 +1,267 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/pomerium/verify/internal/storage"
+	"github.com/pomerium/webauthn"
+	"github.com/pomerium/webauthn/cose"
+)
+
+func newTestWebAuthnServer(t *testing.T, options ...Option) *Server {
+	t.Helper()
+
+	srv := newTestServer(t, options...)
+	srv.storage = storage.NewInMemoryBackend()
+	return srv
+}
+
+func serveTestWebAuthn(t *testing.T, srv *Server, path, origin string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	bs, err := json.Marshal(body)
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "https://verify.example.com/api"+path, bytes.NewReader(bs))
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, r)
+	return w
+}
+
+func newTestAssertionCredential(t *testing.T, challenge []byte, origin string) *webauthn.PublicKeyAssertionCredential {
+	t.Helper()
+
+	clientData, err := json.Marshal(webauthn.CollectedClientData{
+		Type:      webauthn.ClientDataTypeGet,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    origin,
+	})
+	require.NoError(t, err)
+	return &webauthn.PublicKeyAssertionCredential{
+		ID:    "Y3JlZGVudGlhbA",
+		Type:  webauthn.PublicKeyCredentialTypePublicKey,
+		RawID: []byte("credential"),
+		Response: webauthn.AuthenticatorAssertionResponse{
+			ClientDataJSON: clientData,
+		},
+	}
+}
+
+func TestWebAuthnRelyingParty(t *testing.T) {
+	t.Run("request origin", func(t *testing.T) {
+		srv := newTestWebAuthnServer(t)
+
+		w := serveTestWebAuthn(t, srv, "/webauthn/authenticate/begin", "https://verify.example.com", struct{}{})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var options webauthn.PublicKeyCredentialRequestOptions
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+		assert.Equal(t, "verify.example.com", options.RPID)
+	})
+	t.Run("configured origin", func(t *testing.T) {
+		srv := newTestWebAuthnServer(t, WithWebAuthnOrigin("https://verify.corp.example.com/"))
+
+		w := serveTestWebAuthn(t, srv, "/webauthn/register/begin", "https://verify.corp.example.com", map[string]any{
+			"username": "user@example.com",
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var options webauthn.PublicKeyCredentialCreationOptions
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+		assert.Equal(t, "verify.corp.example.com", options.RP.ID)
+
+		w = serveTestWebAuthn(t, srv, "/webauthn/register/begin", "https://verify.example.com", map[string]any{
+			"username": "user@example.com",
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	t.Run("mismatched origin", func(t *testing.T) {
+		srv := newTestWebAuthnServer(t)
+
+		for _, path := range []string{
+			"/webauthn/authenticate/begin",
+			"/webauthn/authenticate/finish",
+			"/webauthn/register/begin",
+			"/webauthn/register/finish",
+		} {
+			w := serveTestWebAuthn(t, srv, path, "https://evil.example.com", map[string]any{
+				"username": "user@example.com",
+			})
+			assert.Equal(t, http.StatusForbidden, w.Code, path)
+			assert.Contains(t, w.Body.String(), errWebAuthnOriginMismatch.Error(), path)
+		}
+	})
+}
+
+func TestWebAuthnInvalidRelyingParty(t *testing.T) {
+	srv := newTestWebAuthnServer(t)
+
+	for _, path := range []string{
+		"/webauthn/authenticate/begin",
+		"/webauthn/authenticate/finish",
+		"/webauthn/register/begin",
+		"/webauthn/register/finish",
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api"+path, bytes.NewReader([]byte(`{"username":"user@example.com"}`)))
+		r.Host = ""
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		assert.Contains(t, w.Body.String(), "invalid origin", path)
+	}
+}
+
+func TestWebAuthnBeginRateLimit(t *testing.T) {
+	srv := newTestWebAuthnServer(t)
+	srv.webAuthnBeginLimiter = rate.NewLimiter(0, 1)
+
+	w := serveTestWebAuthn(t, srv, "/webauthn/authenticate/begin", "", struct{}{})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serveTestWebAuthn(t, srv, "/webauthn/register/begin", "", map[string]any{
+		"username": "user@example.com",
+	})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestWebAuthnRegisterBegin(t *testing.T) {
+	srv := newTestWebAuthnServer(t)
+
+	w := serveTestWebAuthn(t, srv, "/webauthn/register/begin", "", map[string]any{
+		"username": "user@example.com",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var options webauthn.PublicKeyCredentialCreationOptions
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+
+	assert.Len(t, options.User.ID, webAuthnUserHandleSize)
+	assert.NotEqual(t, []byte("user@example.com"), options.User.ID)
+	assert.Equal(t, "user@example.com", options.User.Name)
+	for _, params := range options.PubKeyCredParams {
+		assert.NotEqual(t, cose.AlgorithmRS1, params.COSEAlgorithmIdentifier)
+	}
+}
+
+func TestWebAuthnAuthenticateFinish(t *testing.T) {
+	const origin = "https://verify.example.com"
+
+	begin := func(t *testing.T, srv *Server) []byte {
+		t.Helper()
+
+		w := serveTestWebAuthn(t, srv, "/webauthn/authenticate/begin", origin, struct{}{})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var options webauthn.PublicKeyCredentialRequestOptions
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+		return options.Challenge
+	}
+
+	t.Run("unknown challenge", func(t *testing.T) {
+		srv := newTestWebAuthnServer(t)
+
+		w := serveTestWebAuthn(t, srv, "/webauthn/authenticate/finish", origin,
+			newTestAssertionCredential(t, []byte("unknown"), origin))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), storage.ErrCeremonyNotFound.Error())
+	})
+	t.Run("replayed challenge", func(t *testing.T) {
+		srv := newTestWebAuthnServer(t)
+		credential := newTestAssertionCredential(t, begin(t, srv), origin)
+
+		// the credential is unknown, so the first attempt fails verification
+		w := serveTestWebAuthn(t, srv, "/webauthn/authenticate/finish", origin, credential)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NotContains(t, w.Body.String(), storage.ErrCeremonyNotFound.Error())
+
+		w = serveTestWebAuthn(t, srv, "/webauthn/authenticate/finish", origin, credential)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), storage.ErrCeremonyNotFound.Error())
+	})
+	t.Run("expired challenge", func(t *testing.T) {
+		srv := newTestWebAuthnServer(t)
+		challenge := []byte("expired")
+		require.NoError(t, srv.storage.SetAuthenticateCeremony(context.Background(), &storage.WebAuthnAuthenticateCeremony{
+			Origin:    origin,
+			Options:   &webauthn.PublicKeyCredentialRequestOptions{Challenge: challenge},
+			ExpiresAt: time.Now().Add(-time.Second),
+		}))
+
+		w := serveTestWebAuthn(t, srv, "/webauthn/authenticate/finish", origin,
+			newTestAssertionCredential(t, challenge, origin))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), storage.ErrCeremonyNotFound.Error())
+	})
+}
+
+func TestWebAuthnRegisterFinish(t *testing.T) {
+	const origin = "https://verify.example.com"
+
+	newCredential := func(t *testing.T, challenge []byte) *webauthn.PublicKeyCreationCredential {
+		t.Helper()
+
+		clientData, err := json.Marshal(webauthn.CollectedClientData{
+			Type:      webauthn.ClientDataTypeCreate,
+			Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+			Origin:    origin,
+		})
+		require.NoError(t, err)
+		return &webauthn.PublicKeyCreationCredential{
+			ID:    "Y3JlZGVudGlhbA",
+			Type:  webauthn.PublicKeyCredentialTypePublicKey,
+			RawID: []byte("credential"),
+			Response: webauthn.AuthenticatorAttestationResponse{
+				ClientDataJSON: clientData,
+			},
+		}
+	}
+
+	t.Run("unknown challenge", func(t *testing.T) {
+		srv := newTestWebAuthnServer(t)
+
+		w := serveTestWebAuthn(t, srv, "/webauthn/register/finish", origin, newCredential(t, []byte("unknown")))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), storage.ErrCeremonyNotFound.Error())
+	})
+	t.Run("replayed challenge", func(t *testing.T) {
+		srv := newTestWebAuthnServer(t)
+		w := serveTestWebAuthn(t, srv, "/webauthn/register/begin", origin, map[string]any{
+			"username": "user@example.com",
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var options webauthn.PublicKeyCredentialCreationOptions
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+		credential := newCredential(t, options.Challenge)
+
+		// the attestation is missing, so the first attempt fails verification
+		w = serveTestWebAuthn(t, srv, "/webauthn/register/finish", origin, credential)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NotContains(t, w.Body.String(), storage.ErrCeremonyNotFound.Error())
+
+		w = serveTestWebAuthn(t, srv, "/webauthn/register/finish", origin, credential)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), storage.ErrCeremonyNotFound.Error())
+	})
+	t.Run("expired challenge", func(t *testing.T) {
+		srv := newTestWebAuthnServer(t)
+		challenge := []byte("expired")
+		require.NoError(t, srv.storage.SetRegisterCeremony(context.Background(), &storage.WebAuthnRegisterCeremony{
+			Origin:    origin,
+			Options:   &webauthn.PublicKeyCredentialCreationOptions{Challenge: challenge},
+			ExpiresAt: time.Now().Add(-time.Second),
+		}))
+
+		w := serveTestWebAuthn(t, srv, "/webauthn/register/finish", origin, newCredential(t, challenge))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), storage.ErrCeremonyNotFound.Error())
+	})
+}
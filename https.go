@@ -0,0 +1,92 @@
+package verify
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var errNoCertificate = errors.New("no certificate loaded")
+
+// getCertificate is a tls.Config.GetCertificate callback that returns the
+// current certificate.
+func (c *keyPair) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := c.get()
+	if cert == nil {
+		return nil, errNoCertificate
+	}
+	return cert, nil
+}
+
+// getTLSConfig returns the TLS config used to serve HTTPS.
+func (srv *Server) getTLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     srv.cfg.minTLSVersion,
+		GetCertificate: srv.servingCert.getCertificate,
+	}
+	// client certificates are validated by verify rather than crypto/tls, so
+	// the CA certs can be reloaded and an invalid certificate can be reported
+	// when it is only requested
+	switch srv.cfg.tlsClientAuth {
+	case tlsClientAuthRequest:
+		cfg.ClientAuth = tls.RequestClientCert
+	case tlsClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, err := srv.verifyClientCertificate(cs.PeerCertificates, time.Now())
+			return err
+		}
+	}
+	return cfg
+}
+
+// newHTTPSRedirectHandler returns a handler that redirects requests to the
+// same URL over HTTPS, on the port of the HTTPS bind address.
+func newHTTPSRedirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		u := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     r.URL.Path,
+			RawQuery: r.URL.RawQuery,
+		}
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	})
+}
+
+// getServingCertificateStatus returns the expiry status of the certificate
+// used to serve HTTPS, or nil if verify serves plain HTTP.
+func (srv *Server) getServingCertificateStatus(now time.Time) *certificateStatus {
+	if srv.servingCert == nil {
+		return nil
+	}
+	_, leaf := srv.servingCert.get()
+	if leaf == nil {
+		return nil
+	}
+
+	serverName := leaf.Subject.CommonName
+	if len(leaf.DNSNames) > 0 {
+		serverName = leaf.DNSNames[0]
+	}
+	notAfter := leaf.NotAfter
+	remaining := notAfter.Sub(now)
+	return &certificateStatus{
+		Source:     certificateSourceServing,
+		ServerName: serverName,
+		NotAfter:   &notAfter,
+		ExpiresIn:  remaining.Truncate(time.Second).String(),
+		Warning:    srv.cfg.certExpiryWarning > 0 && remaining < srv.cfg.certExpiryWarning,
+	}
+}
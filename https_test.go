@@ -0,0 +1,116 @@
+package verify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeHTTPS(t *testing.T) {
+	ca := newTestCertificate(t, nil, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Root CA"},
+		IsCA:    true,
+	})
+	newServingCert := func(commonName string) *testCA {
+		return newTestCertificate(t, ca, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: commonName},
+			DNSNames:    []string{"verify.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		})
+	}
+	dir := t.TempDir()
+	certPath, keyPath := writeTestKeyPair(t, dir, newServingCert("first"))
+
+	// the test certificates expire in a day
+	srv := newTestServer(t,
+		WithTLSCertificate(certPath, keyPath),
+		WithMinTLSVersion(tls.VersionTLS13),
+		WithCertExpiryWarning(time.Hour))
+	addr := serveTestHTTPS(t, srv)
+
+	get := func(t *testing.T, maxVersion uint16) (*http.Response, error) {
+		t.Helper()
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:    roots,
+				MaxVersion: maxVersion,
+			},
+			DisableKeepAlives: true,
+		}}
+		res, err := client.Get("https://" + addr + "/healthz")
+		if err == nil {
+			_ = res.Body.Close()
+		}
+		return res, err
+	}
+
+	t.Run("serve", func(t *testing.T) {
+		res, err := get(t, 0)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "first", res.TLS.PeerCertificates[0].Subject.CommonName)
+	})
+	t.Run("reload", func(t *testing.T) {
+		writeTestKeyPair(t, dir, newServingCert("second"))
+		changed, err := srv.servingCert.load()
+		require.NoError(t, err)
+		require.True(t, changed)
+
+		res, err := get(t, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "second", res.TLS.PeerCertificates[0].Subject.CommonName)
+	})
+	t.Run("min version", func(t *testing.T) {
+		_, err := get(t, tls.VersionTLS12)
+		assert.Error(t, err)
+	})
+	t.Run("status", func(t *testing.T) {
+		report := srv.getStatus()
+		assert.Equal(t, statusOK, report.Status)
+		require.Len(t, report.Certificates, 1)
+		assert.Equal(t, certificateSourceServing, report.Certificates[0].Source)
+		assert.Equal(t, "verify.example.com", report.Certificates[0].ServerName)
+		assert.False(t, report.Certificates[0].Warning)
+	})
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, tc := range []struct {
+		httpsAddr string
+		target    string
+		expected  string
+	}{
+		{":8443", "http://verify.example.com:8080/a?b=c", "https://verify.example.com:8443/a?b=c"},
+		{":443", "http://verify.example.com:8080/a", "https://verify.example.com/a"},
+		{"", "http://verify.example.com/", "https://verify.example.com/"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, tc.target, nil)
+		w := httptest.NewRecorder()
+		newHTTPSRedirectHandler(tc.httpsAddr).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+		assert.Equal(t, tc.expected, w.Header().Get("Location"))
+	}
+}
+
+// serveTestHTTPS serves the server's router over HTTPS, returning the address.
+func serveTestHTTPS(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	httpSrv := &http.Server{Handler: srv.router}
+	go func() { _ = httpSrv.Serve(tls.NewListener(li, srv.getTLSConfig())) }()
+	t.Cleanup(func() { _ = httpSrv.Close() })
+	return li.Addr().String()
+}
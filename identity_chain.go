@@ -0,0 +1,228 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+const (
+	headerJWTAssertion    = "X-Pomerium-Jwt-Assertion"
+	headerJWTAssertionFor = "X-Pomerium-Jwt-Assertion-For"
+)
+
+// errForwardedIssuerNotTrusted indicates a forwarded JWT assertion can't be
+// verified because no trusted issuers are configured.
+var errForwardedIssuerNotTrusted = errors.New("forwarded JWT assertions are only verified for trusted issuers, and none are configured")
+
+// untrustedIdentityHeaders are identity headers used by other proxies that
+// Pomerium doesn't set unless they are configured as jwt_claims_headers.
+// Upstream apps may trust them, so they are a likely target for clients trying
+// to impersonate a user.
+var untrustedIdentityHeaders = []string{
+	"Remote-User",
+	"X-Auth-Request-Email",
+	"X-Auth-Request-User",
+	"X-Email",
+	"X-Forwarded-Email",
+	"X-Forwarded-Preferred-Username",
+	"X-Forwarded-User",
+	"X-Remote-User",
+	"X-User",
+}
+
+// An identityHop is one JWT assertion in the chain of Pomerium proxies that
+// handled the request.
+type identityHop struct {
+	Source    string        `json:"source"`
+	Identity  *sdk.Identity `json:"identity"`
+	Verified  bool          `json:"verified"`
+	Error     string        `json:"error,omitempty"`
+	Diagnosis *jwtDiagnosis `json:"diagnosis,omitempty"`
+}
+
+// getIdentityChain verifies the JWT assertion and any forwarded assertions,
+// each against its own issuer's keys. The hops are ordered from the Pomerium
+// nearest to verify to the one the end user connected to, so the last hop
+// identifies the end user.
+func (srv *Server) getIdentityChain(r *http.Request) []identityHop {
+	now := time.Now()
+	var hops []identityHop
+
+	if rawJWT, source, ok := srv.findRawJWT(r); ok {
+		hop := identityHop{Source: source.String()}
+		identity, err := sdk.FromContext(r.Context())
+		if err == nil {
+			hop.Identity = identity
+			hop.Verified = true
+		} else {
+			hop.Identity = getUnverifiedIdentity(rawJWT)
+			hop.Error = err.Error()
+			hop.Diagnosis = srv.diagnoseJWT(rawJWT, err, now)
+		}
+		hops = append(hops, hop)
+	}
+
+	for _, rawJWT := range getForwardedJWTs(r) {
+		hop := identityHop{Source: tokenSourceTypeHeader + ":" + headerJWTAssertionFor}
+		identity, err := srv.getForwardedIdentity(r, rawJWT)
+		if err == nil {
+			hop.Identity = identity
+			hop.Verified = true
+		} else {
+			hop.Identity = getUnverifiedIdentity(rawJWT)
+			hop.Error = err.Error()
+			hop.Diagnosis = srv.diagnoseJWT(rawJWT, err, now)
+		}
+		hops = append(hops, hop)
+	}
+
+	return hops
+}
+
+func (srv *Server) getForwardedIdentity(r *http.Request, rawJWT string) (*sdk.Identity, error) {
+	// forwarded assertions were issued by another Pomerium, so the configured
+	// keys, which are those of the nearest Pomerium, can't verify them
+	if len(srv.issuerVerifiers) == 0 {
+		return nil, errForwardedIssuerNotTrusted
+	}
+	iv, err := srv.getIssuerVerifier(rawJWT)
+	if err != nil {
+		return nil, err
+	}
+	// forwarded assertions were issued for another route, so they have a
+	// different audience, and their issuer was matched when choosing the
+	// verifier
+	expected := iv.expected
+	expected.Issuer = ""
+	expected.Audience = nil
+	return iv.getIdentity(r.Context(), rawJWT, expected)
+}
+
+// getForwardedJWTs returns the X-Pomerium-Jwt-Assertion-For assertions. A
+// JWT never contains a comma, so a chain may be sent either as repeated
+// headers or as a comma-separated list.
+func getForwardedJWTs(r *http.Request) []string {
+	var rawJWTs []string
+	for _, value := range r.Header.Values(headerJWTAssertionFor) {
+		for _, rawJWT := range strings.Split(value, ",") {
+			rawJWT = strings.TrimSpace(rawJWT)
+			if rawJWT != "" {
+				rawJWTs = append(rawJWTs, rawJWT)
+			}
+		}
+	}
+	return rawJWTs
+}
+
+// identity header warning codes
+type identityHeaderWarningCode string
+
+const (
+	identityHeaderWarningDuplicate identityHeaderWarningCode = "duplicate_header"
+	// claim headers were sent without a valid JWT assertion
+	identityHeaderWarningUnsigned identityHeaderWarningCode = "unsigned_header"
+	// a forwarded assertion was sent without a valid JWT assertion
+	identityHeaderWarningForwardedWithoutAssertion identityHeaderWarningCode = "forwarded_without_assertion"
+	identityHeaderWarningUntrusted                 identityHeaderWarningCode = "untrusted_header"
+	// an identity header matches a claim of the verified JWT assertion, so it
+	// is probably one of Pomerium's jwt_claims_headers
+	identityHeaderWarningClaimHeader identityHeaderWarningCode = "claim_header"
+)
+
+// An identityHeaderWarning flags an identity header that may have been set by
+// a client rather than by Pomerium.
+type identityHeaderWarning struct {
+	Code    identityHeaderWarningCode `json:"code"`
+	Header  string                    `json:"header"`
+	Message string                    `json:"message"`
+	// Informational is set when the header is probably not a problem.
+	Informational bool `json:"informational,omitempty"`
+}
+
+// checkIdentityHeaders looks for identity headers that suggest a client tried
+// to inject them. verified is whether the JWT assertion was verified.
+func (srv *Server) checkIdentityHeaders(r *http.Request, rawJWT string, verified bool) []identityHeaderWarning {
+	warnings := []identityHeaderWarning{}
+
+	// Pomerium replaces jwt_claims_headers on every request, so a header with
+	// the value of a verified claim was most likely set by Pomerium
+	claimValues := map[string]string{}
+	if verified {
+		claims, _ := getJWTClaims(rawJWT)
+		for name, value := range claims {
+			v := formatClaimHeaderValue(value)
+			if other, ok := claimValues[v]; !registeredClaims[name] && (!ok || name < other) {
+				claimValues[v] = name
+			}
+		}
+	}
+
+	assertionHeaders := map[string]bool{}
+	for _, ts := range srv.tokenSources {
+		if ts.typ == tokenSourceTypeHeader {
+			assertionHeaders[ts.name] = true
+		}
+	}
+
+	var names []string
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		isAssertion := assertionHeaders[name]
+		isClaim := strings.HasPrefix(strings.ToLower(name), claimHeaderPrefix)
+		if (isAssertion || isClaim) && len(r.Header[name]) > 1 {
+			warnings = append(warnings, identityHeaderWarning{
+				Code:    identityHeaderWarningDuplicate,
+				Header:  name,
+				Message: fmt.Sprintf("%s was sent %d times. Pomerium sends it once, so the other values were probably added by the client.", name, len(r.Header[name])),
+			})
+		}
+		if isClaim && !verified {
+			warnings = append(warnings, identityHeaderWarning{
+				Code:    identityHeaderWarningUnsigned,
+				Header:  name,
+				Message: fmt.Sprintf("%s was sent without a valid JWT assertion, so it cannot be trusted.", name),
+			})
+		}
+	}
+
+	if len(r.Header.Values(headerJWTAssertionFor)) > 0 && !verified {
+		warnings = append(warnings, identityHeaderWarning{
+			Code:    identityHeaderWarningForwardedWithoutAssertion,
+			Header:  headerJWTAssertionFor,
+			Message: headerJWTAssertionFor + " was sent without a valid JWT assertion, so the request did not come through Pomerium.",
+		})
+	}
+
+	for _, name := range untrustedIdentityHeaders {
+		value := r.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		if claim, ok := claimValues[value]; ok && len(r.Header.Values(name)) == 1 {
+			warnings = append(warnings, identityHeaderWarning{
+				Code:          identityHeaderWarningClaimHeader,
+				Header:        name,
+				Message:       fmt.Sprintf("%s matches the %s claim of the JWT assertion, so it is probably one of Pomerium's jwt_claims_headers.", name, claim),
+				Informational: true,
+			})
+		} else {
+			warnings = append(warnings, identityHeaderWarning{
+				Code:    identityHeaderWarningUntrusted,
+				Header:  name,
+				Message: fmt.Sprintf("%s is not set by Pomerium unless it is one of the jwt_claims_headers, so it may have been set by the client. Make sure upstream apps don't trust it.", name),
+			})
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,161 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityChain(t *testing.T) {
+	innerSigner := newTestSigner(t, "inner")
+	innerJWKSSrv := newTestJWKSServer(t, innerSigner.JWK())
+	edgeSigner := newTestSigner(t, "edge")
+	edgeJWKSSrv := newTestJWKSServer(t, edgeSigner.JWK())
+
+	srv := newTestServer(t,
+		WithExpectedJWTAudience("verify.example.com"),
+		WithTrustedIssuer("authenticate.inner.example.com", innerJWKSSrv.URL),
+		WithTrustedIssuer("authenticate.edge.example.com", edgeJWKSSrv.URL),
+	)
+
+	expiry := jwt.NewNumericDate(time.Now().Add(time.Minute))
+	innerJWT := innerSigner.Sign(t, map[string]any{
+		"iss":   "authenticate.inner.example.com",
+		"sub":   "edge-service-account",
+		"aud":   "verify.example.com",
+		"exp":   expiry,
+		"email": "edge@example.com",
+	})
+	edgeJWT := edgeSigner.Sign(t, jwt.Claims{
+		Issuer:   "authenticate.edge.example.com",
+		Subject:  "end-user",
+		Audience: jwt.Audience{"inner.example.com"},
+		Expiry:   expiry,
+	})
+	forgedJWT := innerSigner.Sign(t, jwt.Claims{
+		Issuer:  "authenticate.edge.example.com",
+		Subject: "admin",
+		Expiry:  expiry,
+	})
+
+	type result struct {
+		Error          string                  `json:"error"`
+		Chain          []identityHop           `json:"chain"`
+		HeaderWarnings []identityHeaderWarning `json:"headerWarnings"`
+	}
+	serve := func(t *testing.T, header http.Header) result {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/api/verify-info", nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res result
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res
+	}
+
+	t.Run("chain", func(t *testing.T) {
+		res := serve(t, http.Header{
+			"X-Pomerium-Jwt-Assertion":     {innerJWT},
+			"X-Pomerium-Jwt-Assertion-For": {edgeJWT},
+		})
+		assert.Empty(t, res.Error)
+		assert.Empty(t, res.HeaderWarnings)
+		require.Len(t, res.Chain, 2)
+		assert.Equal(t, "header:"+headerJWTAssertion, res.Chain[0].Source)
+		assert.True(t, res.Chain[0].Verified)
+		assert.Equal(t, "edge-service-account", res.Chain[0].Identity.Subject)
+		assert.Equal(t, "header:"+headerJWTAssertionFor, res.Chain[1].Source)
+		assert.True(t, res.Chain[1].Verified, res.Chain[1].Error)
+		assert.Equal(t, "end-user", res.Chain[1].Identity.Subject)
+	})
+	t.Run("forged forwarded assertion", func(t *testing.T) {
+		res := serve(t, http.Header{
+			"X-Pomerium-Jwt-Assertion":     {innerJWT},
+			"X-Pomerium-Jwt-Assertion-For": {forgedJWT},
+		})
+		require.Len(t, res.Chain, 2)
+		assert.True(t, res.Chain[0].Verified)
+		assert.False(t, res.Chain[1].Verified)
+		assert.Equal(t, "admin", res.Chain[1].Identity.Subject)
+		require.NotNil(t, res.Chain[1].Diagnosis)
+		assert.Equal(t, jwtDiagnosisCodeUnknownKeyID, res.Chain[1].Diagnosis.Code)
+	})
+	t.Run("injected headers", func(t *testing.T) {
+		res := serve(t, http.Header{
+			"X-Pomerium-Jwt-Assertion-For": {edgeJWT},
+			"X-Pomerium-Claim-Email":       {"admin@example.com"},
+			"X-Forwarded-User":             {"admin"},
+		})
+		assert.NotEmpty(t, res.Error)
+		require.Len(t, res.Chain, 1)
+		assert.True(t, res.Chain[0].Verified)
+		assert.Equal(t, []identityHeaderWarning{
+			{Code: identityHeaderWarningUnsigned, Header: "X-Pomerium-Claim-Email"},
+			{Code: identityHeaderWarningForwardedWithoutAssertion, Header: headerJWTAssertionFor},
+			{Code: identityHeaderWarningUntrusted, Header: "X-Forwarded-User"},
+		}, withoutMessages(res.HeaderWarnings))
+	})
+	t.Run("claims headers", func(t *testing.T) {
+		res := serve(t, http.Header{
+			"X-Pomerium-Jwt-Assertion": {innerJWT},
+			"X-Email":                  {"edge@example.com"},
+			"X-User":                   {"admin"},
+		})
+		assert.Empty(t, res.Error)
+		assert.Equal(t, []identityHeaderWarning{
+			{Code: identityHeaderWarningClaimHeader, Header: "X-Email", Informational: true},
+			{Code: identityHeaderWarningUntrusted, Header: "X-User"},
+		}, withoutMessages(res.HeaderWarnings))
+	})
+	t.Run("duplicate headers", func(t *testing.T) {
+		res := serve(t, http.Header{
+			"X-Pomerium-Jwt-Assertion": {innerJWT, forgedJWT},
+		})
+		assert.Equal(t, []identityHeaderWarning{
+			{Code: identityHeaderWarningDuplicate, Header: headerJWTAssertion},
+		}, withoutMessages(res.HeaderWarnings))
+	})
+}
+
+func TestForwardedIdentityWithoutTrustedIssuers(t *testing.T) {
+	signer := newTestSigner(t, "key")
+	jwksSrv := newTestJWKSServer(t, signer.JWK())
+	srv := newTestServer(t,
+		WithJWKSEndpoint(jwksSrv.URL),
+		WithExpectedJWTIssuer("authenticate.inner.example.com"),
+	)
+
+	// even a token signed with the configured keys isn't verified, since they
+	// are the keys of the nearest Pomerium
+	edgeJWT := signer.Sign(t, jwt.Claims{
+		Issuer:  "authenticate.edge.example.com",
+		Subject: "end-user",
+		Expiry:  jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err := srv.getForwardedIdentity(r, edgeJWT)
+	assert.ErrorIs(t, err, errForwardedIssuerNotTrusted)
+
+	d := srv.diagnoseJWT(edgeJWT, err, time.Now())
+	assert.Equal(t, jwtDiagnosisCodeNoTrustedIssuers, d.Code)
+	assert.Nil(t, d.Expected)
+	assert.Equal(t, "authenticate.edge.example.com", d.Actual)
+	assert.Contains(t, d.Hint, "TRUSTED_ISSUERS")
+}
+
+func withoutMessages(warnings []identityHeaderWarning) []identityHeaderWarning {
+	for i := range warnings {
+		warnings[i].Message = ""
+	}
+	return warnings
+}
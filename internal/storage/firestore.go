@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/rs/zerolog/log"
@@ -13,9 +15,16 @@ import (
 )
 
 const (
-	collectionNameWebAuthnAuthenticateRequests = "webauthn-authenticate-requests"
-	collectionNameWebAuthnCredentials          = "webauthn-credentials"
-	collectionNameWebAuthnRegisterRequests     = "webauthn-register-requests"
+	collectionNameWebAuthnAuthenticateCeremonies = "webauthn-authenticate-ceremonies"
+	collectionNameWebAuthnAuthenticateRequests   = "webauthn-authenticate-requests"
+	collectionNameWebAuthnCredentials            = "webauthn-credentials"
+	collectionNameWebAuthnRegisterCeremonies     = "webauthn-register-ceremonies"
+	collectionNameWebAuthnRegisterRequests       = "webauthn-register-requests"
+
+	// maxExpiredCeremonyDeletes is how many expired ceremonies are deleted
+	// when a ceremony is set. Deleting more than one per write keeps abandoned
+	// ceremonies from accumulating.
+	maxExpiredCeremonyDeletes = 10
 )
 
 // FirestoreBackend is used to store data in firestore.
@@ -41,6 +50,12 @@ func (backend *FirestoreBackend) GetCredential(ctx context.Context, credentialID
 	return &credential, nil
 }
 
+// SetAuthenticateCeremony sets a WebAuthn authenticate ceremony in storage.
+func (backend *FirestoreBackend) SetAuthenticateCeremony(ctx context.Context, ceremony *WebAuthnAuthenticateCeremony) error {
+	backend.deleteExpired(ctx, collectionNameWebAuthnAuthenticateCeremonies)
+	return backend.set(ctx, collectionNameWebAuthnAuthenticateCeremonies, ceremony.GetID(), ceremony)
+}
+
 // SetAuthenticateRequest sets a WebAuthn authenticate request in storage.
 func (backend *FirestoreBackend) SetAuthenticateRequest(ctx context.Context, req *WebAuthnAuthenticateRequest) error {
 	return backend.set(ctx, collectionNameWebAuthnAuthenticateRequests, req.GetID(), req)
@@ -52,11 +67,65 @@ func (backend *FirestoreBackend) SetCredential(ctx context.Context, credential *
 	return backend.set(ctx, collectionNameWebAuthnCredentials, id, credential)
 }
 
+// SetRegisterCeremony sets a WebAuthn register ceremony in storage.
+func (backend *FirestoreBackend) SetRegisterCeremony(ctx context.Context, ceremony *WebAuthnRegisterCeremony) error {
+	backend.deleteExpired(ctx, collectionNameWebAuthnRegisterCeremonies)
+	return backend.set(ctx, collectionNameWebAuthnRegisterCeremonies, ceremony.GetID(), ceremony)
+}
+
 // SetRegisterRequest sets a WebAuthn register request in storage.
 func (backend *FirestoreBackend) SetRegisterRequest(ctx context.Context, req *WebAuthnRegisterRequest) error {
 	return backend.set(ctx, collectionNameWebAuthnRegisterRequests, req.GetID(), req)
 }
 
+// TakeAuthenticateCeremony retrieves and deletes a WebAuthn authenticate ceremony from storage.
+func (backend *FirestoreBackend) TakeAuthenticateCeremony(ctx context.Context, id string) (*WebAuthnAuthenticateCeremony, error) {
+	var ceremony WebAuthnAuthenticateCeremony
+	err := backend.take(ctx, collectionNameWebAuthnAuthenticateCeremonies, id, &ceremony)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(ceremony.ExpiresAt) {
+		return nil, ErrCeremonyNotFound
+	}
+	return &ceremony, nil
+}
+
+// TakeRegisterCeremony retrieves and deletes a WebAuthn register ceremony from storage.
+func (backend *FirestoreBackend) TakeRegisterCeremony(ctx context.Context, id string) (*WebAuthnRegisterCeremony, error) {
+	var ceremony WebAuthnRegisterCeremony
+	err := backend.take(ctx, collectionNameWebAuthnRegisterCeremonies, id, &ceremony)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(ceremony.ExpiresAt) {
+		return nil, ErrCeremonyNotFound
+	}
+	return &ceremony, nil
+}
+
+// deleteExpired deletes ceremonies that expired without being taken, e.g.
+// because the user abandoned them. A Firestore TTL policy on ExpiresAt removes
+// them as well, but may take a day to do so.
+func (backend *FirestoreBackend) deleteExpired(ctx context.Context, collectionName string) {
+	snapshots, err := backend.client.Collection(collectionName).
+		Where("ExpiresAt", "<", time.Now()).
+		Limit(maxExpiredCeremonyDeletes).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		log.Error().Err(err).Msg("storage: failed to query expired objects")
+		return
+	}
+	for _, snapshot := range snapshots {
+		_, err := snapshot.Ref.Delete(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("storage: failed to delete expired object")
+			return
+		}
+	}
+}
+
 func (backend *FirestoreBackend) get(ctx context.Context, collectionName, objectID string, dst interface{}) error {
 	collection := backend.client.Collection(collectionName)
 	doc := collection.Doc(objectID)
@@ -84,3 +153,32 @@ func (backend *FirestoreBackend) set(ctx context.Context, collectionName, object
 	log.Info().Str("id", doc.ID).Str("path", doc.Path).Msg("storage: set object")
 	return nil
 }
+
+// take gets and deletes an object in a single transaction so that it can only be used once.
+func (backend *FirestoreBackend) take(ctx context.Context, collectionName, objectID string, dst interface{}) error {
+	collection := backend.client.Collection(collectionName)
+	doc := collection.Doc(objectID)
+	err := backend.client.RunTransaction(ctx, func(_ context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(doc)
+		if status.Code(err) == codes.NotFound {
+			return ErrCeremonyNotFound
+		} else if err != nil {
+			return err
+		}
+
+		err = snapshot.DataTo(dst)
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(doc)
+	})
+	if err != nil {
+		if !errors.Is(err, ErrCeremonyNotFound) {
+			log.Error().Err(err).Msg("storage: failed to take object")
+		}
+		return err
+	}
+	log.Info().Str("id", doc.ID).Str("path", doc.Path).Msg("storage: took object")
+	return nil
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/log"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/pomerium/webauthn"
 )
@@ -30,6 +32,35 @@ func TestFirestoreBackend(t *testing.T) {
 		cred, err := backend.GetCredential(ctx, []byte{1, 2, 3, 4})
 		assert.NoError(t, err)
 		assert.NotNil(t, cred)
+
+		ceremony := &WebAuthnRegisterCeremony{
+			Origin:    "https://verify.example.com",
+			Options:   &webauthn.PublicKeyCredentialCreationOptions{Challenge: []byte{1, 2, 3, 4}},
+			ExpiresAt: time.Now().Add(time.Minute),
+		}
+		err = backend.SetRegisterCeremony(ctx, ceremony)
+		assert.NoError(t, err)
+
+		taken, err := backend.TakeRegisterCeremony(ctx, ceremony.GetID())
+		assert.NoError(t, err)
+		if assert.NotNil(t, taken) {
+			assert.Equal(t, ceremony.Origin, taken.Origin)
+		}
+
+		_, err = backend.TakeRegisterCeremony(ctx, ceremony.GetID())
+		assert.ErrorIs(t, err, ErrCeremonyNotFound)
+
+		expired := &WebAuthnRegisterCeremony{
+			Origin:    "https://verify.example.com",
+			Options:   &webauthn.PublicKeyCredentialCreationOptions{Challenge: []byte{5, 6, 7, 8}},
+			ExpiresAt: time.Now().Add(-time.Minute),
+		}
+		err = backend.SetRegisterCeremony(ctx, expired)
+		assert.NoError(t, err)
+		err = backend.SetRegisterCeremony(ctx, ceremony)
+		assert.NoError(t, err)
+		_, err = client.Collection(collectionNameWebAuthnRegisterCeremonies).Doc(expired.GetID()).Get(ctx)
+		assert.Equal(t, codes.NotFound, status.Code(err), "expired ceremonies should be deleted on write")
 	})
 }
 
@@ -5,20 +5,29 @@ import (
 	"encoding/base64"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/pomerium/webauthn"
 )
 
+// maxInMemoryCeremonies is the maximum number of pending ceremonies of each
+// kind. Once reached, the ceremony closest to expiring is evicted.
+const maxInMemoryCeremonies = 10000
+
 // A InMemoryBackend stores data in-memory.
 type InMemoryBackend struct {
-	mu          sync.Mutex
-	credentials map[string]*webauthn.Credential
+	mu                     sync.Mutex
+	credentials            map[string]*webauthn.Credential
+	authenticateCeremonies map[string]*WebAuthnAuthenticateCeremony
+	registerCeremonies     map[string]*WebAuthnRegisterCeremony
 }
 
 // NewInMemoryBackend creates a new InMemoryBackend.
 func NewInMemoryBackend() *InMemoryBackend {
 	return &InMemoryBackend{
-		credentials: make(map[string]*webauthn.Credential),
+		credentials:            make(map[string]*webauthn.Credential),
+		authenticateCeremonies: make(map[string]*WebAuthnAuthenticateCeremony),
+		registerCeremonies:     make(map[string]*WebAuthnRegisterCeremony),
 	}
 }
 
@@ -34,6 +43,15 @@ func (backend *InMemoryBackend) GetCredential(ctx context.Context, credentialID
 	return credential, nil
 }
 
+// SetAuthenticateCeremony saves an authenticate ceremony.
+func (backend *InMemoryBackend) SetAuthenticateCeremony(ctx context.Context, ceremony *WebAuthnAuthenticateCeremony) error {
+	backend.mu.Lock()
+	setCeremonyLocked(backend.authenticateCeremonies, ceremony.GetID(), ceremony,
+		func(c *WebAuthnAuthenticateCeremony) time.Time { return c.ExpiresAt })
+	backend.mu.Unlock()
+	return nil
+}
+
 // SetAuthenticateRequest is a no-op.
 func (backend *InMemoryBackend) SetAuthenticateRequest(ctx context.Context, req *WebAuthnAuthenticateRequest) error {
 	// ignored
@@ -49,8 +67,63 @@ func (backend *InMemoryBackend) SetCredential(ctx context.Context, credential *w
 	return nil
 }
 
+// SetRegisterCeremony saves a register ceremony.
+func (backend *InMemoryBackend) SetRegisterCeremony(ctx context.Context, ceremony *WebAuthnRegisterCeremony) error {
+	backend.mu.Lock()
+	setCeremonyLocked(backend.registerCeremonies, ceremony.GetID(), ceremony,
+		func(c *WebAuthnRegisterCeremony) time.Time { return c.ExpiresAt })
+	backend.mu.Unlock()
+	return nil
+}
+
 // SetRegisterRequest is a no-op.
 func (backend *InMemoryBackend) SetRegisterRequest(ctx context.Context, req *WebAuthnRegisterRequest) error {
 	// ignored
 	return nil
 }
+
+// TakeAuthenticateCeremony retrieves and deletes an authenticate ceremony.
+func (backend *InMemoryBackend) TakeAuthenticateCeremony(ctx context.Context, id string) (*WebAuthnAuthenticateCeremony, error) {
+	backend.mu.Lock()
+	ceremony, ok := backend.authenticateCeremonies[id]
+	delete(backend.authenticateCeremonies, id)
+	backend.mu.Unlock()
+	if !ok || time.Now().After(ceremony.ExpiresAt) {
+		return nil, ErrCeremonyNotFound
+	}
+	return ceremony, nil
+}
+
+// TakeRegisterCeremony retrieves and deletes a register ceremony.
+func (backend *InMemoryBackend) TakeRegisterCeremony(ctx context.Context, id string) (*WebAuthnRegisterCeremony, error) {
+	backend.mu.Lock()
+	ceremony, ok := backend.registerCeremonies[id]
+	delete(backend.registerCeremonies, id)
+	backend.mu.Unlock()
+	if !ok || time.Now().After(ceremony.ExpiresAt) {
+		return nil, ErrCeremonyNotFound
+	}
+	return ceremony, nil
+}
+
+// setCeremonyLocked adds a ceremony after removing the expired ones, evicting
+// the ceremony closest to expiring if there are too many.
+func setCeremonyLocked[T any](ceremonies map[string]T, id string, ceremony T, getExpiresAt func(T) time.Time) {
+	now := time.Now()
+	for id, c := range ceremonies {
+		if now.After(getExpiresAt(c)) {
+			delete(ceremonies, id)
+		}
+	}
+	if _, ok := ceremonies[id]; !ok && len(ceremonies) >= maxInMemoryCeremonies {
+		var oldestID string
+		var oldest time.Time
+		for id, c := range ceremonies {
+			if expiresAt := getExpiresAt(c); oldestID == "" || expiresAt.Before(oldest) {
+				oldestID, oldest = id, expiresAt
+			}
+		}
+		delete(ceremonies, oldestID)
+	}
+	ceremonies[id] = ceremony
+}
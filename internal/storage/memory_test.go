@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/binary"
 	"testing"
 	"time"
 
@@ -23,3 +24,57 @@ func TestInMemoryBackend(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, cred)
 }
+
+func TestInMemoryBackendCeremonies(t *testing.T) {
+	ctx, clearTimeout := context.WithTimeout(context.Background(), time.Second*10)
+	defer clearTimeout()
+
+	backend := NewInMemoryBackend()
+
+	ceremony := &WebAuthnRegisterCeremony{
+		Origin:    "https://verify.example.com",
+		Options:   &webauthn.PublicKeyCredentialCreationOptions{Challenge: []byte{1, 2, 3, 4}},
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	err := backend.SetRegisterCeremony(ctx, ceremony)
+	assert.NoError(t, err)
+
+	taken, err := backend.TakeRegisterCeremony(ctx, ceremony.GetID())
+	assert.NoError(t, err)
+	assert.Equal(t, ceremony, taken)
+
+	_, err = backend.TakeRegisterCeremony(ctx, ceremony.GetID())
+	assert.ErrorIs(t, err, ErrCeremonyNotFound, "ceremonies should only be usable once")
+
+	expired := &WebAuthnAuthenticateCeremony{
+		Origin:    "https://verify.example.com",
+		Options:   &webauthn.PublicKeyCredentialRequestOptions{Challenge: []byte{5, 6, 7, 8}},
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	err = backend.SetAuthenticateCeremony(ctx, expired)
+	assert.NoError(t, err)
+
+	_, err = backend.TakeAuthenticateCeremony(ctx, expired.GetID())
+	assert.ErrorIs(t, err, ErrCeremonyNotFound, "expired ceremonies should not be returned")
+}
+
+func TestInMemoryBackendMaxCeremonies(t *testing.T) {
+	ctx, clearTimeout := context.WithTimeout(context.Background(), time.Second*10)
+	defer clearTimeout()
+
+	backend := NewInMemoryBackend()
+	now := time.Now()
+	for i := 0; i <= maxInMemoryCeremonies; i++ {
+		err := backend.SetRegisterCeremony(ctx, &WebAuthnRegisterCeremony{
+			Options:   &webauthn.PublicKeyCredentialCreationOptions{Challenge: binary.BigEndian.AppendUint32(nil, uint32(i))},
+			ExpiresAt: now.Add(time.Minute + time.Duration(i)),
+		})
+		assert.NoError(t, err)
+	}
+	assert.Len(t, backend.registerCeremonies, maxInMemoryCeremonies)
+
+	_, err := backend.TakeRegisterCeremony(ctx, WebAuthnRegisterCeremony{
+		Options: &webauthn.PublicKeyCredentialCreationOptions{Challenge: binary.BigEndian.AppendUint32(nil, 0)},
+	}.GetID())
+	assert.ErrorIs(t, err, ErrCeremonyNotFound, "the ceremony closest to expiring should be evicted")
+}
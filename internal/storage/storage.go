@@ -3,16 +3,43 @@ package storage
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"time"
 
 	"github.com/pomerium/webauthn"
 )
 
+// ErrCeremonyNotFound is the error used to indicate a WebAuthn ceremony doesn't exist, has already been used or has
+// expired.
+var ErrCeremonyNotFound = errors.New("ceremony not found")
+
 // A Backend stores credentials and requests.
 type Backend interface {
 	GetCredential(ctx context.Context, credentialID []byte) (*webauthn.Credential, error)
+	SetAuthenticateCeremony(ctx context.Context, ceremony *WebAuthnAuthenticateCeremony) error
 	SetAuthenticateRequest(ctx context.Context, req *WebAuthnAuthenticateRequest) error
 	SetCredential(ctx context.Context, credential *webauthn.Credential) error
+	SetRegisterCeremony(ctx context.Context, ceremony *WebAuthnRegisterCeremony) error
 	SetRegisterRequest(ctx context.Context, req *WebAuthnRegisterRequest) error
+	// TakeAuthenticateCeremony retrieves and deletes an authenticate ceremony. If the ceremony doesn't exist or has
+	// expired ErrCeremonyNotFound is returned.
+	TakeAuthenticateCeremony(ctx context.Context, id string) (*WebAuthnAuthenticateCeremony, error)
+	// TakeRegisterCeremony retrieves and deletes a register ceremony. If the ceremony doesn't exist or has expired
+	// ErrCeremonyNotFound is returned.
+	TakeRegisterCeremony(ctx context.Context, id string) (*WebAuthnRegisterCeremony, error)
+}
+
+// WebAuthnAuthenticateCeremony is the server-generated state for a pending authenticate ceremony.
+type WebAuthnAuthenticateCeremony struct {
+	Origin    string                                      `json:"origin"`
+	Options   *webauthn.PublicKeyCredentialRequestOptions `json:"options"`
+	ExpiresAt time.Time                                   `json:"expiresAt"`
+}
+
+// GetID gets the ID for the ceremony. It is the base64url encoded challenge, which is what the client returns in its
+// client data.
+func (ceremony WebAuthnAuthenticateCeremony) GetID() string {
+	return base64.RawURLEncoding.EncodeToString(ceremony.Options.Challenge)
 }
 
 // WebAuthnAuthenticateRequest is the authenticate request info and credential.
@@ -26,6 +53,19 @@ func (req WebAuthnAuthenticateRequest) GetID() string {
 	return base64.RawURLEncoding.EncodeToString(req.Credential.RawID)
 }
 
+// WebAuthnRegisterCeremony is the server-generated state for a pending register ceremony.
+type WebAuthnRegisterCeremony struct {
+	Origin    string                                       `json:"origin"`
+	Options   *webauthn.PublicKeyCredentialCreationOptions `json:"options"`
+	ExpiresAt time.Time                                    `json:"expiresAt"`
+}
+
+// GetID gets the ID for the ceremony. It is the base64url encoded challenge, which is what the client returns in its
+// client data.
+func (ceremony WebAuthnRegisterCeremony) GetID() string {
+	return base64.RawURLEncoding.EncodeToString(ceremony.Options.Challenge)
+}
+
 // WebAuthnRegisterRequest is the register request info and credential.
 type WebAuthnRegisterRequest struct {
 	Options    *webauthn.PublicKeyCredentialCreationOptions `json:"options"`
@@ -0,0 +1,376 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/rs/zerolog/log"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+var (
+	// errUntrustedIssuer indicates a JWT was issued by an issuer that is not
+	// in the list of trusted issuers.
+	errUntrustedIssuer = errors.New("JWT issuer is not trusted")
+	// errJWKSFetch indicates the keys to verify a JWT couldn't be fetched or
+	// loaded.
+	errJWKSFetch = errors.New("failed to fetch JWKS")
+)
+
+// An issuerVerifier verifies JWTs for a single issuer. Each issuer has its own
+// JWKS endpoint, key cache and TLS trust roots.
+type issuerVerifier struct {
+	// issuer is empty for the default verifier, which accepts any issuer
+	issuer       string
+	jwksEndpoint string
+	jwksCache    *jwksCache
+	tlsVerifier  *tlsVerifier
+	// staticJWKS is set when keys are loaded from a file or inline data
+	// instead of the JWKS endpoint
+	staticJWKS *staticJWKS
+	// discovery is set when the JWKS endpoint is resolved with OIDC discovery
+	discovery *oidcDiscovery
+
+	client   *http.Client
+	expected jwt.Expected
+	leeway   time.Duration
+	// allowedSigningAlgorithms is empty to allow any asymmetric algorithm
+	allowedSigningAlgorithms []string
+}
+
+func newIssuerVerifier(issuer, jwksEndpoint string, jwksCacheTTL time.Duration, tlsVerifier *tlsVerifier) *issuerVerifier {
+	return &issuerVerifier{
+		issuer:       issuer,
+		jwksEndpoint: jwksEndpoint,
+		jwksCache:    newJWKSCache(jwksCacheTTL),
+		tlsVerifier:  tlsVerifier,
+	}
+}
+
+func (iv *issuerVerifier) init(expected *jwt.Expected, leeway time.Duration, allowedSigningAlgorithms []string) error {
+	var transport http.RoundTripper
+	if iv.staticJWKS != nil {
+		_, err := iv.loadStaticJWKS()
+		if err != nil {
+			return fmt.Errorf("failed to load jwks: %w", err)
+		}
+		transport = iv.staticJWKS
+	} else {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialTLSContext = iv.tlsVerifier.DialTLSContext
+		transport = t
+	}
+
+	iv.client = &http.Client{
+		Transport: iv.jwksCache.Transport(transport),
+		Timeout:   maxRemoteWait,
+	}
+	iv.expected = *expected
+	iv.leeway = leeway
+	iv.allowedSigningAlgorithms = allowedSigningAlgorithms
+	return nil
+}
+
+// getIdentity verifies a JWT assertion and returns the identity. It works like
+// sdk.Verifier.GetIdentity, but with a configurable leeway for the time-based
+// claims and an allowlist of signing algorithms.
+func (iv *issuerVerifier) getIdentity(ctx context.Context, rawJWT string, expected jwt.Expected) (*sdk.Identity, error) {
+	sig, err := iv.parseJWT(rawJWT)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := iv.getKeys(ctx, rawJWT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve signature key for Pomerium JWT assertion: %w", err)
+	}
+
+	identity, _, err := iv.verifyJWT(sig, keys, expected)
+	return identity, err
+}
+
+// parseJWT parses a compact JWS and checks its signing algorithm against the
+// allowlist.
+func (iv *issuerVerifier) parseJWT(rawJWT string) (*jose.JSONWebSignature, error) {
+	sig, err := parseCompactJWS(rawJWT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Pomerium JWT assertion: %w", err)
+	}
+	err = checkSigningAlgorithm(sig.Signatures[0].Protected.Algorithm, iv.allowedSigningAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// verifyJWT verifies the signature of a parsed JWT assertion with one of the
+// keys and validates its claims. The key is returned once the signature has
+// been verified, even if the claims are invalid.
+func (iv *issuerVerifier) verifyJWT(sig *jose.JSONWebSignature, keys []*jose.JSONWebKey, expected jwt.Expected) (*sdk.Identity, *jose.JSONWebKey, error) {
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("failed to retrieve signature key for Pomerium JWT assertion: %w", sdk.ErrJWKNotFound)
+	}
+
+	// keys without a key id are tried in turn
+	var key *jose.JSONWebKey
+	var payload []byte
+	var err error
+	for _, key = range keys {
+		payload, err = sig.Verify(key)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Pomerium JWT assertion signature: %w", err)
+	}
+
+	jwkBytes, err := key.MarshalJSON()
+	if err != nil {
+		return nil, key, fmt.Errorf("failed to marshal signature key for Pomerium JWT assertion: %w", err)
+	}
+
+	var identity sdk.Identity
+	err = json.Unmarshal(payload, &identity)
+	// claims with a different type than in sdk.Identity, such as groups
+	// objects, are left unset and only reported as raw claims
+	var typeErr *json.UnmarshalTypeError
+	if err != nil && !errors.As(err, &typeErr) {
+		return nil, key, fmt.Errorf("failed to unmarshal Pomerium JWT assertion: %w", err)
+	}
+	identity.PublicKey = string(jwkBytes)
+
+	if expected.Time.IsZero() {
+		expected.Time = time.Now()
+	}
+	err = identity.Claims.ValidateWithLeeway(expected, iv.leeway)
+	if err != nil {
+		return nil, key, fmt.Errorf("unexpected Pomerium JWT assertion claim: %w", err)
+	}
+	return &identity, key, nil
+}
+
+// getKeys returns the keys that may verify the JWT from the cache, fetching the
+// JWKS if they are missing.
+func (iv *issuerVerifier) getKeys(ctx context.Context, rawJWT string) ([]*jose.JSONWebKey, error) {
+	tok, err := jwt.ParseSigned(rawJWT)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", err)
+	}
+	if len(tok.Headers) != 1 {
+		return nil, sdk.ErrMultipleHeaders
+	}
+	keyID := tok.Headers[0].KeyID
+	if keys := iv.jwksCache.Get(keyID); len(keys) > 0 {
+		return keys, nil
+	}
+	if iv.staticJWKS != nil {
+		// static keys are always cached, so there is nothing to fetch
+		return nil, sdk.ErrJWKNotFound
+	}
+
+	var claims jwt.Claims
+	err = tok.UnsafeClaimsWithoutVerification(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", err)
+	}
+	jwksEndpoint, err := iv.resolveJWKSEndpoint(ctx, claims.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().
+		Str("kid", keyID).
+		Str("jwks-endpoint", jwksEndpoint).
+		Msg("key not found, fetching jwks")
+	jwks, err := sdk.FetchJSONWebKeySet(ctx, iv.client, jwksEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w from %s: %w", errJWKSFetch, jwksEndpoint, err)
+	}
+	iv.jwksCache.Add(jwks.Keys)
+
+	// use the fetched keys rather than the cache, which expires them straight
+	// away if the endpoint forbids caching
+	if keys := findJWKs(jwks.Keys, keyID); len(keys) > 0 {
+		return keys, nil
+	}
+	return nil, sdk.ErrJWKNotFound
+}
+
+// findJWKs returns the key with the key id, or else every key without a key
+// id. Unlike jwksCache.Get it falls back to keys without a key id for tokens
+// with a key id, since the keys have just been fetched.
+func findJWKs(keys []jose.JSONWebKey, keyID string) []*jose.JSONWebKey {
+	var keyless []*jose.JSONWebKey
+	for i := range keys {
+		switch {
+		case keyID != "" && keys[i].KeyID == keyID:
+			return []*jose.JSONWebKey{&keys[i]}
+		case keys[i].KeyID == "":
+			keyless = append(keyless, &keys[i])
+		}
+	}
+	return keyless
+}
+
+// getJWKSEndpointInfo returns the JWKS endpoint used to verify tokens from the
+// given issuer, without fetching anything. When using OIDC discovery the URL
+// is empty until the discovery document has been fetched.
+func (iv *issuerVerifier) getJWKSEndpointInfo(issuer string) jwksEndpointInfo {
+	switch {
+	case iv.staticJWKS != nil:
+		return jwksEndpointInfo{URL: iv.jwksEndpoint, Source: jwksEndpointSourceStatic}
+	case iv.jwksEndpoint != "":
+		return jwksEndpointInfo{URL: iv.jwksEndpoint, Source: jwksEndpointSourceConfig}
+	case iv.discovery != nil:
+		info := jwksEndpointInfo{
+			Source:       jwksEndpointSourceOIDCDiscovery,
+			DiscoveryURL: iv.discovery.discoveryURL,
+		}
+		if entry, ok := iv.discovery.getCached(); ok {
+			info.URL = entry.jwksURI
+			if entry.err != nil {
+				info.Error = entry.err.Error()
+			}
+		}
+		return info
+	}
+
+	// the same default as the sdk
+	info := jwksEndpointInfo{Source: jwksEndpointSourceDefault}
+	rawURL := issuer
+	if !strings.HasPrefix(rawURL, "https://") && !strings.HasPrefix(rawURL, "http://") {
+		rawURL = "https://" + rawURL
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		if u.Path == "" {
+			u.Path = defaultJWKSPath
+		}
+		info.URL = u.String()
+	}
+	return info
+}
+
+// getJWKSEndpoint returns the JWKS endpoint used to verify tokens from the
+// given issuer, or the OIDC discovery URL if the endpoint hasn't been
+// discovered yet.
+func (iv *issuerVerifier) getJWKSEndpoint(issuer string) string {
+	info := iv.getJWKSEndpointInfo(issuer)
+	if info.URL == "" {
+		return info.DiscoveryURL
+	}
+	return info.URL
+}
+
+// resolveJWKSEndpoint returns the JWKS endpoint used to verify tokens from the
+// given issuer, fetching the OIDC discovery document if needed. Discovery is
+// only done for the configured issuer, so tokens from any other issuer are
+// rejected before anything is fetched.
+func (iv *issuerVerifier) resolveJWKSEndpoint(ctx context.Context, issuer string) (string, error) {
+	info := iv.getJWKSEndpointInfo(issuer)
+	if info.Source != jwksEndpointSourceOIDCDiscovery {
+		return info.URL, nil
+	}
+	if !iv.discovery.matchesIssuer(issuer) {
+		return "", fmt.Errorf("%w: OIDC discovery is only done for %q, not %q",
+			jwt.ErrInvalidIssuer, iv.discovery.issuer, issuer)
+	}
+
+	jwksURI, err := iv.discovery.resolve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to resolve JWKS endpoint from %s: %w", errJWKSFetch, info.DiscoveryURL, err)
+	}
+	return jwksURI, nil
+}
+
+// getIssuerVerifier returns the verifier for the issuer of the raw JWT. If no
+// trusted issuers are configured the default verifier is returned.
+func (srv *Server) getIssuerVerifier(rawJWT string) (*issuerVerifier, error) {
+	if len(srv.issuerVerifiers) == 0 {
+		return srv.defaultVerifier, nil
+	}
+
+	tok, err := jwt.ParseSigned(rawJWT)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", err)
+	}
+	var claims jwt.Claims
+	err = tok.UnsafeClaimsWithoutVerification(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", err)
+	}
+
+	iv, ok := srv.issuerVerifiers[normalizeIssuer(claims.Issuer)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errUntrustedIssuer, claims.Issuer)
+	}
+	return iv, nil
+}
+
+// getTrustedIssuers returns the sorted list of trusted issuers.
+func (srv *Server) getTrustedIssuers() []string {
+	issuers := make([]string, 0, len(srv.issuerVerifiers))
+	for _, iv := range srv.issuerVerifiers {
+		issuers = append(issuers, iv.issuer)
+	}
+	sort.Strings(issuers)
+	return issuers
+}
+
+// getAllIssuerVerifiers returns the default verifier followed by the verifiers
+// for each trusted issuer.
+func (srv *Server) getAllIssuerVerifiers() []*issuerVerifier {
+	ivs := []*issuerVerifier{srv.defaultVerifier}
+	for _, issuer := range srv.getTrustedIssuers() {
+		ivs = append(ivs, srv.issuerVerifiers[normalizeIssuer(issuer)])
+	}
+	return ivs
+}
+
+// addIdentityToRequest is middleware that verifies the JWT assertion and
+// stores the identity in the request context, like sdk.AddIdentityToRequest,
+// but using the verifier for the token's issuer.
+func (srv *Server) addIdentityToRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := srv.getIdentity(r)
+		next.ServeHTTP(w, r.WithContext(sdk.NewContext(r.Context(), identity, err)))
+	})
+}
+
+func (srv *Server) getIdentity(r *http.Request) (*sdk.Identity, error) {
+	rawJWT := srv.getRawJWT(r)
+	if rawJWT == "" {
+		return nil, sdk.ErrTokenNotFound
+	}
+
+	iv, err := srv.getIssuerVerifier(rawJWT)
+	if err != nil {
+		return nil, err
+	}
+
+	return iv.getIdentity(r.Context(), rawJWT, iv.expected)
+}
+
+// normalizeIssuer strips the scheme and trailing slash from an issuer and
+// lowercases its host so that "https://Authenticate.example.com/" and
+// "authenticate.example.com" match.
+func normalizeIssuer(issuer string) string {
+	issuer = strings.TrimPrefix(issuer, "https://")
+	issuer = strings.TrimPrefix(issuer, "http://")
+	issuer = strings.TrimSuffix(issuer, "/")
+	host, path, found := strings.Cut(issuer, "/")
+	if !found {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(host) + "/" + path
+}
@@ -0,0 +1,69 @@
+package verify
+
+import (
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedIssuers(t *testing.T) {
+	stagingSigner := newTestSigner(t, "staging")
+	stagingJWKSSrv := newTestJWKSServer(t, stagingSigner.JWK())
+	prodSigner := newTestSigner(t, "prod")
+	prodJWKSSrv := httptest.NewTLSServer(newTestJWKSHandler(prodSigner.JWK()))
+	t.Cleanup(prodJWKSSrv.Close)
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: prodJWKSSrv.Certificate().Raw,
+	}), 0o600))
+
+	srv := newTestServer(t,
+		WithTrustedIssuer("authenticate.staging.example.com", stagingJWKSSrv.URL),
+		WithTrustedIssuer("https://authenticate.example.com/", prodJWKSSrv.URL, caPath),
+	)
+
+	sign := func(signer *testSigner, issuer string) string {
+		return signer.Sign(t, jwt.Claims{
+			Issuer: issuer,
+			Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		})
+	}
+
+	t.Run("staging", func(t *testing.T) {
+		identity, err := verifyTestJWT(t, srv, sign(stagingSigner, "authenticate.staging.example.com"))
+		require.NoError(t, err)
+		assert.Equal(t, "authenticate.staging.example.com", identity.Issuer)
+	})
+	t.Run("prod", func(t *testing.T) {
+		identity, err := verifyTestJWT(t, srv, sign(prodSigner, "authenticate.example.com"))
+		require.NoError(t, err)
+		assert.Equal(t, "authenticate.example.com", identity.Issuer)
+
+		iv := srv.issuerVerifiers["authenticate.example.com"]
+		assert.NoError(t, iv.tlsVerifier.GetTLSError("127.0.0.1"),
+			"the issuer's CA should be trusted for its JWKS endpoint")
+	})
+	t.Run("wrong keys", func(t *testing.T) {
+		_, err := verifyTestJWT(t, srv, sign(stagingSigner, "authenticate.example.com"))
+		assert.Error(t, err)
+	})
+	t.Run("untrusted", func(t *testing.T) {
+		rawJWT := sign(stagingSigner, "authenticate.other.example.com")
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		assert.ErrorIs(t, err, errUntrustedIssuer)
+
+		d := srv.diagnoseJWT(rawJWT, err, time.Now())
+		assert.Equal(t, jwtDiagnosisCodeIssuerMismatch, d.Code)
+		assert.Equal(t, []string{"authenticate.staging.example.com", "https://authenticate.example.com/"}, d.Expected)
+		assert.Equal(t, "authenticate.other.example.com", d.Actual)
+	})
+}
@@ -0,0 +1,490 @@
+package verify
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/rs/zerolog/log"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+const (
+	maxJWKSCacheSize = 1024
+	maxJWKSBodySize  = 4 * 1024 * 1024
+	// defaultJWKSCacheTTL is used when neither the config nor the JWKS
+	// endpoint's Cache-Control header set a TTL
+	defaultJWKSCacheTTL = time.Hour
+	// minJWKSRefetchInterval limits how often a JWKS endpoint is fetched,
+	// e.g. when tokens present an unknown kid
+	minJWKSRefetchInterval = 10 * time.Second
+)
+
+type jwksSource struct {
+	url       string
+	fetchedAt time.Time
+	expiresAt time.Time
+}
+
+// A jwksCacheEntry is a cached key. Entries are stored by key id, or by
+// thumbprint for keys without a key id, such as keys loaded from PEM files.
+type jwksCacheEntry struct {
+	key *jose.JSONWebKey
+	jwksSource
+	// static is set for keys loaded from a file or inline data, which are
+	// never fetched again and so are kept by Flush
+	static bool
+}
+
+// A jwksFetch is the last JWKS document fetched from a URL.
+type jwksFetch struct {
+	source jwksSource
+	body   []byte
+	keyIDs []string
+}
+
+// A jwksCache holds the JSON Web Keys of an issuerVerifier and remembers where
+// and when each key was fetched.
+//
+// Keys expire after the TTL set by the config or the JWKS endpoint's
+// Cache-Control header. issuerVerifier.getKeys fetches the JWKS document
+// whenever a key is missing, so an unknown kid or an expired key triggers a
+// refetch, limited to one per minJWKSRefetchInterval per URL. Keys that are no
+// longer in a refetched document are removed, so rotated keys stop verifying
+// tokens.
+type jwksCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*jwksCacheEntry
+	// sources are recorded by the transport when a JWKS document is fetched
+	// and consumed when getKeys adds the keys to the cache
+	sources map[string]jwksSource
+	fetches map[string]*jwksFetch
+}
+
+// newJWKSCache creates a new jwksCache. If ttl is 0 the JWKS endpoint's
+// Cache-Control header is used.
+func newJWKSCache(ttl time.Duration) *jwksCache {
+	return &jwksCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*jwksCacheEntry),
+		sources: make(map[string]jwksSource),
+		fetches: make(map[string]*jwksFetch),
+	}
+}
+
+// Get gets the keys that may verify a token with the key id from the cache.
+// Tokens without a key id may be verified by any key without a key id. Tokens
+// with a key id need the key with that id, so that an unknown key id triggers a
+// refetch, except that static keys without a key id are tried as well.
+func (c *jwksCache) Get(keyID string) []*jose.JSONWebKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	var keys []*jose.JSONWebKey
+	for cacheKey, entry := range c.entries {
+		if !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt) {
+			delete(c.entries, cacheKey)
+			continue
+		}
+		if keyID != "" && entry.key.KeyID == keyID {
+			return []*jose.JSONWebKey{entry.key}
+		}
+		if entry.key.KeyID == "" && (keyID == "" || entry.static) {
+			keys = append(keys, entry.key)
+		}
+	}
+	return keys
+}
+
+// Add adds the keys of a JWKS document to the cache.
+func (c *jwksCache) Add(keys []jose.JSONWebKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range keys {
+		key := &keys[i]
+		source, ok := c.sources[key.KeyID]
+		if !ok {
+			source.fetchedAt = c.now()
+			source.expiresAt = source.fetchedAt.Add(c.getTTL(nil))
+		}
+
+		cacheKey := getJWKSCacheKey(key)
+		if _, ok := c.entries[cacheKey]; !ok && len(c.entries) >= maxJWKSCacheSize {
+			c.evictOldestLocked()
+		}
+		c.entries[cacheKey] = &jwksCacheEntry{key: key, jwksSource: source}
+	}
+	for i := range keys {
+		delete(c.sources, keys[i].KeyID)
+	}
+}
+
+// Replace replaces the keys from the given source URL. The keys don't expire.
+func (c *jwksCache) Replace(u string, keys []jose.JSONWebKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for cacheKey, entry := range c.entries {
+		if entry.url == u {
+			delete(c.entries, cacheKey)
+		}
+	}
+	delete(c.fetches, u)
+
+	source := jwksSource{url: u, fetchedAt: c.now()}
+	for i := range keys {
+		key := keys[i]
+		c.entries[getJWKSCacheKey(&key)] = &jwksCacheEntry{key: &key, jwksSource: source, static: true}
+	}
+}
+
+// Flush removes every fetched key from the cache, so that the next token is
+// verified with freshly fetched keys. Static keys are kept. It returns the
+// number of keys removed.
+func (c *jwksCache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int
+	for cacheKey, entry := range c.entries {
+		if !entry.static {
+			delete(c.entries, cacheKey)
+			n++
+		}
+	}
+	c.sources = make(map[string]jwksSource)
+	c.fetches = make(map[string]*jwksFetch)
+	return n
+}
+
+// Transport wraps an http.RoundTripper so that the source of any JWKS
+// document it fetches is recorded. Requests made within
+// minJWKSRefetchInterval of the last fetch of the same URL are answered with
+// the last document.
+func (c *jwksCache) Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		u := req.URL.String()
+
+		c.mu.Lock()
+		last, ok := c.fetches[u]
+		if ok && c.now().Sub(last.source.fetchedAt) < minJWKSRefetchInterval {
+			for _, keyID := range last.keyIDs {
+				c.sources[keyID] = last.source
+			}
+			c.mu.Unlock()
+			return &http.Response{
+				Status:     http.StatusText(http.StatusOK),
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       io.NopCloser(bytes.NewReader(last.body)),
+				Request:    req,
+			}, nil
+		}
+		c.mu.Unlock()
+
+		res, err := next.RoundTrip(req)
+		if err != nil || res.StatusCode != http.StatusOK {
+			return res, err
+		}
+
+		bs, err := io.ReadAll(io.LimitReader(res.Body, maxJWKSBodySize))
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(bs))
+
+		var jwks struct {
+			Keys []struct {
+				KeyID string `json:"kid"`
+			} `json:"keys"`
+		}
+		if json.Unmarshal(bs, &jwks) == nil {
+			now := c.now()
+			fetch := &jwksFetch{
+				source: jwksSource{url: u, fetchedAt: now, expiresAt: now.Add(c.getTTL(res.Header))},
+				body:   bs,
+			}
+			current := make(map[string]bool)
+			for _, key := range jwks.Keys {
+				fetch.keyIDs = append(fetch.keyIDs, key.KeyID)
+				current[key.KeyID] = true
+			}
+
+			c.mu.Lock()
+			c.fetches[u] = fetch
+			for _, keyID := range fetch.keyIDs {
+				c.sources[keyID] = fetch.source
+			}
+			// remove keys that were rotated out
+			for cacheKey, entry := range c.entries {
+				if entry.url == u && !current[entry.key.KeyID] {
+					delete(c.entries, cacheKey)
+				}
+			}
+			c.mu.Unlock()
+		}
+		return res, nil
+	})
+}
+
+// getTTL returns how long keys from a JWKS document with the given response
+// headers should be cached. Zero means the keys are only used to verify the
+// token they were fetched for, and are fetched again for the next one.
+func (c *jwksCache) getTTL(header http.Header) time.Duration {
+	if c.ttl > 0 {
+		return c.ttl
+	}
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return defaultJWKSCacheTTL
+}
+
+// A jwksKeyInfo describes a cached JSON Web Key.
+type jwksKeyInfo struct {
+	// Issuer is the trusted issuer the key was fetched for, if any.
+	Issuer     string    `json:"issuer,omitempty"`
+	KeyID      string    `json:"kid"`
+	Algorithm  string    `json:"alg,omitempty"`
+	Use        string    `json:"use,omitempty"`
+	KeyType    string    `json:"kty"`
+	Curve      string    `json:"crv,omitempty"`
+	KeySize    int       `json:"keySize,omitempty"`
+	Thumbprint string    `json:"thumbprint"`
+	SourceURL  string    `json:"sourceUrl,omitempty"`
+	FetchedAt  time.Time `json:"fetchedAt"`
+	// ExpiresAt is nil for keys that don't expire.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// VerifiedRequest is set when the key verified the current request's JWT.
+	VerifiedRequest bool `json:"verifiedRequest"`
+}
+
+// Keys returns information about every cached key, ordered by fetch time.
+func (c *jwksCache) Keys() []jwksKeyInfo {
+	c.mu.Lock()
+	infos := make([]jwksKeyInfo, 0, len(c.entries))
+	for _, entry := range c.entries {
+		info := getJWKInfo(entry.key)
+		info.SourceURL = entry.url
+		info.FetchedAt = entry.fetchedAt
+		if !entry.expiresAt.IsZero() {
+			expiresAt := entry.expiresAt
+			info.ExpiresAt = &expiresAt
+		}
+		infos = append(infos, info)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].FetchedAt.Equal(infos[j].FetchedAt) {
+			return infos[i].FetchedAt.Before(infos[j].FetchedAt)
+		}
+		if infos[i].KeyID != infos[j].KeyID {
+			return infos[i].KeyID < infos[j].KeyID
+		}
+		return infos[i].Thumbprint < infos[j].Thumbprint
+	})
+	return infos
+}
+
+func (c *jwksCache) evictOldestLocked() {
+	var oldestCacheKey string
+	var oldest *jwksCacheEntry
+	for cacheKey, entry := range c.entries {
+		if oldest == nil || entry.fetchedAt.Before(oldest.fetchedAt) {
+			oldestCacheKey, oldest = cacheKey, entry
+		}
+	}
+	delete(c.entries, oldestCacheKey)
+}
+
+// getJWKSCacheKey returns the key id of the key, or its thumbprint if it has no
+// key id, so that several keys without a key id can be cached.
+func getJWKSCacheKey(key *jose.JSONWebKey) string {
+	if key.KeyID != "" {
+		return "kid:" + key.KeyID
+	}
+	thumbprint, _ := key.Thumbprint(crypto.SHA256)
+	return "thumbprint:" + base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+func getJWKInfo(key *jose.JSONWebKey) jwksKeyInfo {
+	info := jwksKeyInfo{
+		KeyID:     key.KeyID,
+		Algorithm: key.Algorithm,
+		Use:       key.Use,
+	}
+	switch k := key.Key.(type) {
+	case *ecdsa.PublicKey:
+		info.KeyType = "EC"
+		info.Curve = k.Curve.Params().Name
+		info.KeySize = k.Curve.Params().BitSize
+	case *rsa.PublicKey:
+		info.KeyType = "RSA"
+		info.KeySize = k.N.BitLen()
+	case ed25519.PublicKey:
+		info.KeyType = "OKP"
+		info.Curve = "Ed25519"
+	default:
+		info.KeyType = "unknown"
+	}
+	if thumbprint, err := key.Thumbprint(crypto.SHA256); err == nil {
+		info.Thumbprint = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+	return info
+}
+
+func (srv *Server) serveAPIJWKS(w http.ResponseWriter, r *http.Request) {
+	var keys []jwksKeyInfo
+	for _, iv := range srv.getAllIssuerVerifiers() {
+		for _, key := range iv.jwksCache.Keys() {
+			key.Issuer = iv.issuer
+			keys = append(keys, key)
+		}
+	}
+
+	// mark the key that verified the current request
+	if identity, err := sdk.FromContext(r.Context()); err == nil && identity != nil {
+		var verifiedKey jose.JSONWebKey
+		if verifiedKey.UnmarshalJSON([]byte(identity.PublicKey)) == nil {
+			verifiedThumbprint := getJWKInfo(&verifiedKey).Thumbprint
+			for i := range keys {
+				keys[i].VerifiedRequest = keys[i].Thumbprint == verifiedThumbprint
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"keys": keys,
+	})
+}
+
+// serveAPIJWKSFlush flushes the keys fetched from JWKS endpoints. It requires
+// the admin token rather than a JWT assertion, which may no longer verify
+// against the cached keys, e.g. after a key rotation.
+func (srv *Server) serveAPIJWKSFlush(w http.ResponseWriter, r *http.Request) {
+	err := srv.checkAdminToken(r)
+	switch {
+	case errors.Is(err, errAdminTokenNotConfigured):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var flushed int
+	for _, iv := range srv.getAllIssuerVerifiers() {
+		flushed += iv.jwksCache.Flush()
+	}
+	log.Info().
+		Str("remote-addr", r.RemoteAddr).
+		Int("keys", flushed).
+		Msg("flushed jwks cache")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"flushed": flushed,
+	})
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// parseJWKS parses a JSON Web Key Set, a single JSON Web Key or PEM encoded
+// public keys and certificates.
+func parseJWKS(bs []byte) (*jose.JSONWebKeySet, error) {
+	trimmed := bytes.TrimSpace(bs)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var jwks jose.JSONWebKeySet
+		err := json.Unmarshal(trimmed, &jwks)
+		if err != nil {
+			return nil, err
+		}
+		if len(jwks.Keys) == 0 {
+			var jwk jose.JSONWebKey
+			if json.Unmarshal(trimmed, &jwk) == nil && jwk.Valid() {
+				jwks.Keys = append(jwks.Keys, jwk)
+			}
+		}
+		for i := range jwks.Keys {
+			jwks.Keys[i] = jwks.Keys[i].Public()
+		}
+		if len(jwks.Keys) == 0 {
+			return nil, sdk.ErrJWKSNotFound
+		}
+		return &jwks, nil
+	}
+
+	var jwks jose.JSONWebKeySet
+	for rest := trimmed; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		var key any
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", strings.ToLower(block.Type), err)
+		}
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{Key: key, Use: "sig"})
+	}
+	if len(jwks.Keys) == 0 {
+		return nil, sdk.ErrJWKSNotFound
+	}
+	return &jwks, nil
+}
@@ -0,0 +1,135 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// jwksFileWatchInterval is how often a JWKS file is checked for changes.
+// Polling also picks up Kubernetes secret updates, which replace a symlink
+// rather than write to the file.
+const jwksFileWatchInterval = 5 * time.Second
+
+// A staticJWKS serves keys from a local file or inline data instead of a JWKS
+// endpoint. It is used as the transport of the issuer verifier's JWKS client,
+// so that issuerVerifier.getKeys "fetches" the local keys.
+type staticJWKS struct {
+	// path is empty for inline data
+	path string
+	data []byte
+	url  string
+
+	mu   sync.Mutex
+	raw  []byte
+	jwks *jose.JSONWebKeySet
+	body []byte
+}
+
+func newStaticJWKSFile(path string) *staticJWKS {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return &staticJWKS{
+		path: path,
+		url:  (&url.URL{Scheme: "file", Path: path}).String(),
+	}
+}
+
+func newStaticJWKSData(data []byte) *staticJWKS {
+	return &staticJWKS{
+		data: data,
+		url:  "data:",
+	}
+}
+
+// load reads the keys, returning them and whether they changed since the
+// last load. If the keys are invalid the previous keys are kept.
+func (s *staticJWKS) load() (*jose.JSONWebKeySet, bool, error) {
+	bs := s.data
+	if s.path != "" {
+		var err error
+		bs, err = os.ReadFile(s.path)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jwks != nil && bytes.Equal(bs, s.raw) {
+		return s.jwks, false, nil
+	}
+
+	jwks, err := parseJWKS(bs)
+	if err != nil {
+		return nil, false, err
+	}
+	body, err := json.Marshal(jwks)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.raw, s.jwks, s.body = bs, jwks, body
+	return jwks, true, nil
+}
+
+// RoundTrip responds to any request with the last loaded keys.
+func (s *staticJWKS) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	body := s.body
+	s.mu.Unlock()
+
+	return &http.Response{
+		Status:     http.StatusText(http.StatusOK),
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// loadStaticJWKS loads the issuer's local keys into its cache.
+func (iv *issuerVerifier) loadStaticJWKS() (bool, error) {
+	jwks, changed, err := iv.staticJWKS.load()
+	if err != nil {
+		return false, err
+	}
+	if changed {
+		iv.jwksCache.Replace(iv.staticJWKS.url, jwks.Keys)
+	}
+	return changed, nil
+}
+
+// watchJWKSFile reloads the issuer's JWKS file whenever it changes, until the
+// context is canceled.
+func (iv *issuerVerifier) watchJWKSFile(ctx context.Context) {
+	ticker := time.NewTicker(jwksFileWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := iv.loadStaticJWKS()
+		if err != nil {
+			log.Error().Err(err).Str("path", iv.staticJWKS.path).Msg("failed to reload jwks file, keeping previous keys")
+		} else if changed {
+			log.Info().Str("path", iv.staticJWKS.path).Msg("reloaded jwks file")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+func TestJWKSFile(t *testing.T) {
+	signer1 := newTestSigner(t, "key-1")
+	signer2 := newTestSigner(t, "key-2")
+	claims := jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+	token1 := signer1.Sign(t, claims)
+	token2 := signer2.Sign(t, claims)
+
+	encodePEM := func(t *testing.T, signer *testSigner) []byte {
+		der, err := x509.MarshalPKIXPublicKey(signer.key.Public())
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		bs, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{signer1.JWK()}})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, bs, 0o600))
+
+		srv := newTestServer(t, WithJWKSFile(path))
+		iv := srv.defaultVerifier
+
+		_, err = verifyTestJWT(t, srv, token1)
+		assert.NoError(t, err)
+		_, err = verifyTestJWT(t, srv, token2)
+		assert.ErrorIs(t, err, sdk.ErrJWKNotFound)
+		assert.Equal(t, "file://"+path, srv.diagnoseJWT(token2, err, time.Now()).JWKSEndpoint)
+
+		// rotate to a PEM encoded key, which has no key id
+		require.NoError(t, os.WriteFile(path, encodePEM(t, signer2), 0o600))
+		changed, err := iv.loadStaticJWKS()
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		_, err = verifyTestJWT(t, srv, token2)
+		assert.NoError(t, err)
+		_, err = verifyTestJWT(t, srv, token1)
+		assert.Error(t, err, "rotated keys should be removed")
+
+		// invalid files are ignored
+		require.NoError(t, os.WriteFile(path, []byte("invalid"), 0o600))
+		_, err = iv.loadStaticJWKS()
+		assert.Error(t, err)
+		_, err = verifyTestJWT(t, srv, token2)
+		assert.NoError(t, err)
+
+		keys := iv.jwksCache.Keys()
+		require.Len(t, keys, 1)
+		assert.Equal(t, "file://"+path, keys[0].SourceURL)
+		assert.Nil(t, keys[0].ExpiresAt)
+	})
+	t.Run("multiple pem keys", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.pem")
+		bs := append(encodePEM(t, signer1), encodePEM(t, signer2)...)
+		require.NoError(t, os.WriteFile(path, bs, 0o600))
+
+		srv := newTestServer(t, WithJWKSFile(path))
+
+		_, err := verifyTestJWT(t, srv, token1)
+		assert.NoError(t, err)
+		_, err = verifyTestJWT(t, srv, token2)
+		assert.NoError(t, err)
+		assert.Len(t, srv.defaultVerifier.jwksCache.Keys(), 2)
+
+		report, err := CheckToken(context.Background(), token1, path)
+		require.NoError(t, err)
+		assert.True(t, report.Valid, report.Error)
+	})
+	t.Run("data", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSData(string(encodePEM(t, signer1))))
+
+		_, err := verifyTestJWT(t, srv, token1)
+		assert.NoError(t, err)
+		_, err = verifyTestJWT(t, srv, token2)
+		assert.ErrorIs(t, err, jose.ErrCryptoFailure)
+	})
+}
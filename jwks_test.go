@@ -0,0 +1,269 @@
+package verify
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+func TestServeAPIJWKS(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	otherSigner := newTestSigner(t, "key-2")
+	jwksSrv := newTestJWKSServer(t, signer.JWK(), otherSigner.JWK())
+
+	srv := New(WithJWKSEndpoint(jwksSrv.URL))
+	srv.initRouter()
+
+	rawJWT := signer.Sign(t, jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/jwks", nil)
+	r.Header.Set("X-Pomerium-Jwt-Assertion", rawJWT)
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var res struct {
+		Keys []jwksKeyInfo `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.Len(t, res.Keys, 2)
+
+	jwk := signer.JWK()
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+
+	for _, key := range res.Keys {
+		assert.Equal(t, jwksSrv.URL, key.SourceURL)
+		assert.Equal(t, "EC", key.KeyType)
+		assert.Equal(t, "P-256", key.Curve)
+		assert.Equal(t, "ES256", key.Algorithm)
+		assert.False(t, key.FetchedAt.IsZero())
+		if key.KeyID == "key-1" {
+			assert.True(t, key.VerifiedRequest)
+			assert.Equal(t, base64.RawURLEncoding.EncodeToString(thumbprint), key.Thumbprint)
+		} else {
+			assert.False(t, key.VerifiedRequest)
+		}
+	}
+}
+
+func TestJWKSCacheRotation(t *testing.T) {
+	signer1 := newTestSigner(t, "key-1")
+	signer2 := newTestSigner(t, "key-2")
+
+	var mu sync.Mutex
+	keys := []jose.JSONWebKey{signer1.JWK()}
+	cacheControl := ""
+	fetches := 0
+	jwksSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fetches++
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		newTestJWKSHandler(keys...).ServeHTTP(w, r)
+	}))
+	t.Cleanup(jwksSrv.Close)
+	rotate := func(newKeys ...jose.JSONWebKey) {
+		mu.Lock()
+		keys = newKeys
+		mu.Unlock()
+	}
+	getFetches := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches
+	}
+
+	srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL), WithAdminToken("admin-token"))
+	now := time.Now()
+	srv.defaultVerifier.jwksCache.now = func() time.Time { return now }
+
+	claims := jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+	token1 := signer1.Sign(t, claims)
+	token2 := signer2.Sign(t, claims)
+
+	_, err := verifyTestJWT(t, srv, token1)
+	require.NoError(t, err)
+	_, err = verifyTestJWT(t, srv, token1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, getFetches(), "keys should be cached")
+
+	rotate(signer2.JWK())
+
+	_, err = verifyTestJWT(t, srv, token2)
+	assert.ErrorIs(t, err, sdk.ErrJWKNotFound)
+	assert.Equal(t, 1, getFetches(), "unknown kid refetches should be rate limited")
+
+	now = now.Add(minJWKSRefetchInterval)
+	_, err = verifyTestJWT(t, srv, token2)
+	assert.NoError(t, err, "unknown kid should trigger a refetch")
+	assert.Equal(t, 2, getFetches())
+
+	_, err = verifyTestJWT(t, srv, token1)
+	assert.ErrorIs(t, err, sdk.ErrJWKNotFound, "rotated keys should be removed")
+	assert.Equal(t, 2, getFetches())
+
+	t.Run("ttl", func(t *testing.T) {
+		mu.Lock()
+		cacheControl = "public, max-age=60"
+		mu.Unlock()
+
+		srv.defaultVerifier.jwksCache.Flush()
+		_, err := verifyTestJWT(t, srv, token2)
+		require.NoError(t, err)
+		assert.Equal(t, 3, getFetches())
+
+		infos := srv.defaultVerifier.jwksCache.Keys()
+		require.Len(t, infos, 1)
+		require.NotNil(t, infos[0].ExpiresAt)
+		assert.Equal(t, now.Add(time.Minute), *infos[0].ExpiresAt)
+
+		now = now.Add(time.Minute)
+		_, err = verifyTestJWT(t, srv, token2)
+		require.NoError(t, err)
+		assert.Equal(t, 4, getFetches(), "expired keys should be refetched")
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		flush := func(t *testing.T, srv *Server, header, value string) *httptest.ResponseRecorder {
+			t.Helper()
+
+			r := httptest.NewRequest(http.MethodPost, "/api/jwks/flush", nil)
+			if header != "" {
+				r.Header.Set(header, value)
+			}
+			w := httptest.NewRecorder()
+			srv.router.ServeHTTP(w, r)
+			return w
+		}
+
+		w := flush(t, srv, headerJWTAssertion, token2)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "a verified JWT shouldn't be enough to flush")
+		w = flush(t, srv, "Authorization", "Bearer wrong-token")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = flush(t, newTestServer(t, WithJWKSEndpoint(jwksSrv.URL)), "Authorization", "Bearer admin-token")
+		assert.Equal(t, http.StatusForbidden, w.Code, "flush should be disabled without an admin token")
+
+		w = flush(t, srv, "Authorization", "Bearer admin-token")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"flushed":1}`, w.Body.String())
+		assert.Empty(t, srv.defaultVerifier.jwksCache.Keys())
+
+		_, err = verifyTestJWT(t, srv, token2)
+		require.NoError(t, err)
+		assert.Equal(t, 5, getFetches(), "flush should allow an immediate refetch")
+	})
+}
+
+func TestJWKSCacheTTL(t *testing.T) {
+	for _, tc := range []struct {
+		ttl          time.Duration
+		cacheControl string
+		expect       time.Duration
+	}{
+		{expect: defaultJWKSCacheTTL},
+		{cacheControl: "max-age=300", expect: 5 * time.Minute},
+		{cacheControl: "no-store", expect: 0},
+		{ttl: time.Minute, cacheControl: "max-age=300", expect: time.Minute},
+	} {
+		c := newJWKSCache(tc.ttl)
+		assert.Equal(t, tc.expect, c.getTTL(http.Header{"Cache-Control": {tc.cacheControl}}), tc.cacheControl)
+	}
+}
+
+func TestJWKSNoCache(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	for _, cacheControl := range []string{"no-cache", "no-store", "max-age=0"} {
+		t.Run(cacheControl, func(t *testing.T) {
+			jwksSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", cacheControl)
+				newTestJWKSHandler(signer.JWK()).ServeHTTP(w, r)
+			}))
+			t.Cleanup(jwksSrv.Close)
+
+			srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL))
+			rawJWT := signer.Sign(t, jwt.Claims{
+				Issuer: "authenticate.example.com",
+				Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			})
+			for i := 0; i < 2; i++ {
+				_, err := verifyTestJWT(t, srv, rawJWT)
+				assert.NoError(t, err, "keys should be used for the fetch that returned them")
+			}
+		})
+	}
+}
+
+func TestJWKSCacheKeyless(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	keyless := newTestSigner(t, "")
+
+	var mu sync.Mutex
+	keys := []jose.JSONWebKey{keyless.JWK()}
+	fetches := 0
+	jwksSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fetches++
+		newTestJWKSHandler(keys...).ServeHTTP(w, r)
+	}))
+	t.Cleanup(jwksSrv.Close)
+
+	srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL))
+	now := time.Now()
+	srv.defaultVerifier.jwksCache.now = func() time.Time { return now }
+	claims := jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+
+	_, err := verifyTestJWT(t, srv, keyless.Sign(t, claims))
+	require.NoError(t, err)
+
+	mu.Lock()
+	keys = append(keys, signer.JWK())
+	mu.Unlock()
+	now = now.Add(minJWKSRefetchInterval)
+
+	_, err = verifyTestJWT(t, srv, signer.Sign(t, claims))
+	assert.NoError(t, err, "an unknown kid should refetch even with keys without a kid cached")
+	mu.Lock()
+	assert.Equal(t, 2, fetches)
+	mu.Unlock()
+}
+
+func TestJWKSCacheFlushStatic(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	bs, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{signer.JWK()}})
+	require.NoError(t, err)
+
+	srv := newTestServer(t, WithJWKSData(string(bs)))
+	assert.Equal(t, 0, srv.defaultVerifier.jwksCache.Flush())
+	_, err = verifyTestJWT(t, srv, signer.Sign(t, jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}))
+	assert.NoError(t, err, "static keys should survive a flush")
+}
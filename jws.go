@@ -0,0 +1,98 @@
+package verify
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-jose/go-jose/v3"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+// errDisallowedSigningAlgorithm indicates a JWT was signed with an algorithm
+// that is not allowed.
+var errDisallowedSigningAlgorithm = errors.New("JWT signing algorithm is not allowed")
+
+// errNotCompactJWS indicates a JWT was not in the compact serialization.
+var errNotCompactJWS = errors.New("JWT is not a compact JWS")
+
+// A jwsHeader is the protected header of a JWS. Only the fields relevant to
+// verification are included.
+type jwsHeader struct {
+	Algorithm   string `json:"alg,omitempty"`
+	KeyID       string `json:"kid,omitempty"`
+	Type        string `json:"typ,omitempty"`
+	ContentType string `json:"cty,omitempty"`
+	JWKSetURL   string `json:"jku,omitempty"`
+	X509URL     string `json:"x5u,omitempty"`
+}
+
+// getJWSHeader returns the protected header of a compact JWS.
+func getJWSHeader(rawJWT string) (*jwsHeader, error) {
+	var header jwsHeader
+	err := json.Unmarshal(getJWSProtectedHeader(rawJWT), &header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWS protected header: %w", err)
+	}
+	return &header, nil
+}
+
+// getJWSProtectedHeader returns the decoded protected header of a compact JWS.
+func getJWSProtectedHeader(rawJWT string) []byte {
+	encoded, _, _ := strings.Cut(rawJWT, ".")
+	bs, _ := base64.RawURLEncoding.DecodeString(encoded)
+	return bs
+}
+
+// isAsymmetricSigningAlgorithm returns true if alg is a public key signature
+// algorithm. Pomerium signs assertions with a private key, so "none" and the
+// HMAC algorithms are never valid.
+func isAsymmetricSigningAlgorithm(alg string) bool {
+	switch jose.SignatureAlgorithm(alg) {
+	case jose.EdDSA,
+		jose.ES256, jose.ES384, jose.ES512,
+		jose.PS256, jose.PS384, jose.PS512,
+		jose.RS256, jose.RS384, jose.RS512:
+		return true
+	}
+	return false
+}
+
+// parseCompactJWS parses a JWS with a single signature in the compact
+// serialization. jose.ParseSigned also accepts the JSON serialization, which
+// Pomerium never sends and whose header isn't the token's first segment, so it
+// is rejected.
+func parseCompactJWS(rawJWT string) (*jose.JSONWebSignature, error) {
+	if strings.HasPrefix(strings.TrimSpace(rawJWT), "{") {
+		return nil, errNotCompactJWS
+	}
+	sig, err := jose.ParseSigned(rawJWT)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig.Signatures) != 1 {
+		return nil, sdk.ErrMultipleHeaders
+	}
+	return sig, nil
+}
+
+// checkSigningAlgorithm returns an error if the signing algorithm is not
+// allowed. Symmetric algorithms and "none" are always rejected. If no
+// algorithms are allowed explicitly any asymmetric algorithm is allowed.
+func checkSigningAlgorithm(alg string, allowed []string) error {
+	if !isAsymmetricSigningAlgorithm(alg) {
+		return fmt.Errorf("%w: %q", errDisallowedSigningAlgorithm, alg)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, a := range allowed {
+		if a == alg {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", errDisallowedSigningAlgorithm, alg)
+}
@@ -0,0 +1,144 @@
+package verify
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningAlgorithms(t *testing.T) {
+	claims := jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+
+	ecSigner := newTestSigner(t, "ec")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaSigner, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: rsaKey, KeyID: "rsa"},
+	}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("jku", "https://attacker.example.com/jwks.json"))
+	require.NoError(t, err)
+	rsaJWT, err := jwt.Signed(rsaSigner).Claims(claims).CompactSerialize()
+	require.NoError(t, err)
+
+	hmacSigner, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.HS256,
+		Key:       []byte("0123456789abcdef0123456789abcdef"),
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	require.NoError(t, err)
+	hmacJWT, err := jwt.Signed(hmacSigner).Claims(claims).CompactSerialize()
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	noneJWT := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + "."
+
+	jwksSrv := newTestJWKSServer(t, ecSigner.JWK(), jose.JSONWebKey{
+		Key: rsaKey.Public(), KeyID: "rsa", Algorithm: string(jose.RS256), Use: "sig",
+	})
+
+	for _, tc := range []struct {
+		name     string
+		options  []Option
+		rawJWT   string
+		allowed  bool
+		expected any
+	}{
+		{name: "es256 default", rawJWT: ecSigner.Sign(t, claims), allowed: true},
+		{name: "rs256 default", rawJWT: rsaJWT, allowed: true},
+		{name: "none", rawJWT: noneJWT},
+		{name: "hmac", rawJWT: hmacJWT},
+		{
+			name:    "es256 allowed",
+			options: []Option{WithAllowedSigningAlgorithms("ES256")},
+			rawJWT:  ecSigner.Sign(t, claims),
+			allowed: true,
+		},
+		{
+			name:     "rs256 not allowed",
+			options:  []Option{WithAllowedSigningAlgorithms("ES256")},
+			rawJWT:   rsaJWT,
+			expected: []string{"ES256"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, append([]Option{WithJWKSEndpoint(jwksSrv.URL)}, tc.options...)...)
+
+			_, err := verifyTestJWT(t, srv, tc.rawJWT)
+			if tc.allowed {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, errDisallowedSigningAlgorithm)
+
+			d := srv.diagnoseJWT(tc.rawJWT, err, time.Now())
+			assert.Equal(t, jwtDiagnosisCodeDisallowedAlg, d.Code)
+			assert.Equal(t, tc.expected, d.Expected)
+		})
+	}
+
+	t.Run("json serialization", func(t *testing.T) {
+		jws, err := rsaSigner.Sign(payload)
+		require.NoError(t, err)
+		rsaJSON := jws.FullSerialize()
+		options := []Option{WithJWKSEndpoint(jwksSrv.URL), WithAllowedSigningAlgorithms("ES256")}
+		srv := newTestServer(t, options...)
+
+		_, err = verifyTestJWT(t, srv, rsaJSON)
+		assert.ErrorIs(t, err, errNotCompactJWS)
+		assert.Equal(t, jwtDiagnosisCodeMalformedJWS, srv.diagnoseJWT(rsaJSON, err, time.Now()).Code)
+
+		_, err = srv.defaultVerifier.getIdentity(context.Background(), rsaJSON, srv.defaultVerifier.expected)
+		assert.ErrorIs(t, err, errNotCompactJWS, "the verifier should not rely on the caller's checks")
+
+		// forwarded assertions are checked by the trusted issuer's verifier
+		trustedSrv := newTestServer(t,
+			WithTrustedIssuer("authenticate.example.com", jwksSrv.URL),
+			WithAllowedSigningAlgorithms("ES256"))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		_, err = trustedSrv.getForwardedIdentity(r, rsaJSON)
+		assert.ErrorIs(t, err, errNotCompactJWS)
+		_, err = trustedSrv.getForwardedIdentity(r, rsaJWT)
+		assert.ErrorIs(t, err, errDisallowedSigningAlgorithm)
+
+		report, err := CheckToken(context.Background(), rsaJSON, "", options...)
+		require.NoError(t, err)
+		assert.False(t, report.Valid)
+		assert.Equal(t, string(jwtDiagnosisCodeMalformedJWS), report.Code)
+	})
+	t.Run("header", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL))
+
+		r := httptest.NewRequest(http.MethodGet, "/api/verify-info", nil)
+		r.Header.Set(headerJWTAssertion, rsaJWT)
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			JWTHeader jwsHeader `json:"jwtHeader"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, jwsHeader{
+			Algorithm: "RS256",
+			KeyID:     "rsa",
+			Type:      "JWT",
+			JWKSetURL: "https://attacker.example.com/jwks.json",
+		}, res.JWTHeader)
+	})
+}
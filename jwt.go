@@ -0,0 +1,175 @@
+package verify
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+const defaultJWKSPath = "/.well-known/pomerium/jwks.json"
+
+// A jwtDiagnosisCode is a stable identifier for the reason JWT verification failed.
+type jwtDiagnosisCode string
+
+// jwt diagnosis codes
+const (
+	jwtDiagnosisCodeMissingHeader    jwtDiagnosisCode = "missing_header"
+	jwtDiagnosisCodeMalformedJWS     jwtDiagnosisCode = "malformed_jws"
+	jwtDiagnosisCodeUnknownKeyID     jwtDiagnosisCode = "unknown_kid"
+	jwtDiagnosisCodeJWKSFetchFailed  jwtDiagnosisCode = "jwks_fetch_failed"
+	jwtDiagnosisCodeJWKSTLSFailed    jwtDiagnosisCode = "jwks_tls_failed"
+	jwtDiagnosisCodeBadSignature     jwtDiagnosisCode = "bad_signature"
+	jwtDiagnosisCodeDisallowedAlg    jwtDiagnosisCode = "disallowed_alg"
+	jwtDiagnosisCodeExpired          jwtDiagnosisCode = "expired"
+	jwtDiagnosisCodeNotYetValid      jwtDiagnosisCode = "not_yet_valid"
+	jwtDiagnosisCodeIssuerMismatch   jwtDiagnosisCode = "issuer_mismatch"
+	jwtDiagnosisCodeAudienceMismatch jwtDiagnosisCode = "audience_mismatch"
+	// jwtDiagnosisCodeNoTrustedIssuers is used for forwarded assertions when
+	// no trusted issuers are configured to verify them with
+	jwtDiagnosisCodeNoTrustedIssuers jwtDiagnosisCode = "no_trusted_issuers"
+	jwtDiagnosisCodeUnknown          jwtDiagnosisCode = "unknown"
+)
+
+// A jwtDiagnosis is a machine-readable explanation of why JWT verification
+// failed.
+type jwtDiagnosis struct {
+	Code         jwtDiagnosisCode `json:"code"`
+	Error        string           `json:"error"`
+	Hint         string           `json:"hint"`
+	Claim        string           `json:"claim,omitempty"`
+	Expected     any              `json:"expected,omitempty"`
+	Actual       any              `json:"actual,omitempty"`
+	ServerTime   *jwt.NumericDate `json:"serverTime,omitempty"`
+	JWKSEndpoint string           `json:"jwksEndpoint,omitempty"`
+}
+
+// newJWTExpected returns the claims every verified JWT must have.
+func newJWTExpected(cfg *config) *jwt.Expected {
+	expected := &jwt.Expected{
+		Issuer: cfg.expectedJWTIssuer,
+	}
+	if aud := cfg.expectedJWTAudience; aud != "" {
+		expected.Audience = jwt.Audience([]string{aud})
+	}
+	return expected
+}
+
+// diagnoseJWT classifies a verification error for the given raw JWT.
+func (srv *Server) diagnoseJWT(rawJWT string, err error, now time.Time) *jwtDiagnosis {
+	d := &jwtDiagnosis{
+		Code:  jwtDiagnosisCodeUnknown,
+		Error: err.Error(),
+		Hint:  "Check the verify logs for more details.",
+	}
+
+	if errors.Is(err, sdk.ErrTokenNotFound) {
+		d.Code = jwtDiagnosisCodeMissingHeader
+		d.Hint = "No JWT assertion was found on the request. Make sure the request is routed through Pomerium and " +
+			"that the route has pass_identity_headers enabled."
+		return d
+	}
+
+	if errors.Is(err, errDisallowedSigningAlgorithm) {
+		d.Code = jwtDiagnosisCodeDisallowedAlg
+		d.Claim = "alg"
+		if header, err := getJWSHeader(rawJWT); err == nil {
+			d.Actual = header.Algorithm
+		}
+		if algs := srv.cfg.allowedSigningAlgorithms; len(algs) > 0 {
+			d.Expected = algs
+		}
+		d.Hint = "The JWT was signed with an algorithm that is not allowed. Pomerium signs assertions with its " +
+			"signing_key, so the token may have been forged or ALLOWED_SIGNING_ALGORITHMS does not match the key type."
+		return d
+	}
+
+	tok, parseErr := jwt.ParseSigned(rawJWT)
+	if parseErr != nil || errors.Is(err, sdk.ErrMultipleHeaders) || errors.Is(err, errNotCompactJWS) {
+		d.Code = jwtDiagnosisCodeMalformedJWS
+		d.Hint = "The JWT assertion is not a valid compact JWS with a single signature. Make sure nothing between " +
+			"Pomerium and verify modifies the header."
+		return d
+	}
+
+	var claims jwt.Claims
+	_ = tok.UnsafeClaimsWithoutVerification(&claims)
+	if iv, err := srv.getIssuerVerifier(rawJWT); err == nil {
+		d.JWKSEndpoint = iv.getJWKSEndpoint(claims.Issuer)
+	}
+
+	switch {
+	case errors.Is(err, sdk.ErrJWKNotFound):
+		d.Code = jwtDiagnosisCodeUnknownKeyID
+		d.Claim = "kid"
+		d.Actual = tok.Headers[0].KeyID
+		d.Hint = "The key that signed the JWT is not published at the JWKS endpoint. Make sure Pomerium's " +
+			"signing_key matches the keys served by the JWKS endpoint."
+	case errors.Is(err, jose.ErrCryptoFailure):
+		d.Code = jwtDiagnosisCodeBadSignature
+		d.Hint = "The JWT signature does not match the key published at the JWKS endpoint. The token may have " +
+			"been modified or signed by a different Pomerium instance."
+	case errors.Is(err, jwt.ErrExpired):
+		d.Code = jwtDiagnosisCodeExpired
+		d.Claim = "exp"
+		d.Actual = claims.Expiry
+		d.ServerTime = jwt.NewNumericDate(now)
+		d.Hint = "The JWT has expired. If the token was just issued, check that the clocks on the Pomerium and " +
+			"verify hosts are synchronized, or increase JWT_LEEWAY."
+	case errors.Is(err, jwt.ErrNotValidYet):
+		d.Code = jwtDiagnosisCodeNotYetValid
+		d.Claim = "nbf"
+		d.Actual = claims.NotBefore
+		d.ServerTime = jwt.NewNumericDate(now)
+		d.Hint = "The JWT is not valid yet. Check that the clocks on the Pomerium and verify hosts are " +
+			"synchronized, or increase JWT_LEEWAY."
+	case errors.Is(err, jwt.ErrIssuedInTheFuture):
+		d.Code = jwtDiagnosisCodeNotYetValid
+		d.Claim = "iat"
+		d.Actual = claims.IssuedAt
+		d.ServerTime = jwt.NewNumericDate(now)
+		d.Hint = "The JWT was issued in the future. Check that the clocks on the Pomerium and verify hosts are " +
+			"synchronized, or increase JWT_LEEWAY."
+	case errors.Is(err, errForwardedIssuerNotTrusted):
+		d.Code = jwtDiagnosisCodeNoTrustedIssuers
+		d.Claim = "iss"
+		d.Actual = claims.Issuer
+		d.Hint = "Forwarded assertions are issued by another Pomerium and can only be verified with its keys. " +
+			"Configure TRUSTED_ISSUERS to verify forwarded assertions."
+	case errors.Is(err, errUntrustedIssuer):
+		d.Code = jwtDiagnosisCodeIssuerMismatch
+		d.Claim = "iss"
+		d.Expected = srv.getTrustedIssuers()
+		d.Actual = claims.Issuer
+		d.Hint = "The JWT issuer is not one of the trusted issuers. Add it with TRUSTED_ISSUERS if verify should " +
+			"accept tokens from it."
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		d.Code = jwtDiagnosisCodeIssuerMismatch
+		d.Claim = "iss"
+		d.Expected = srv.cfg.expectedJWTIssuer
+		d.Actual = claims.Issuer
+		d.Hint = "The JWT issuer does not match EXPECTED_JWT_ISSUER. Update the setting to the authenticate " +
+			"domain of the Pomerium instance routing to verify."
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		d.Code = jwtDiagnosisCodeAudienceMismatch
+		d.Claim = "aud"
+		d.Expected = srv.cfg.expectedJWTAudience
+		d.Actual = claims.Audience
+		d.Hint = "The JWT audience does not match EXPECTED_JWT_AUDIENCE. Update the setting to the route's " +
+			"external domain."
+	case isTLSError(err):
+		d.Code = jwtDiagnosisCodeJWKSTLSFailed
+		d.Hint = "The TLS connection to the JWKS endpoint failed. Check that the endpoint's certificate is " +
+			"trusted, adding its CA with EXTRA_CA_CERTS if needed, and that it matches SPKI_PINS. With STRICT_TLS " +
+			"keys are never fetched over an untrusted connection."
+	case errors.Is(err, errJWKSFetch):
+		d.Code = jwtDiagnosisCodeJWKSFetchFailed
+		d.Hint = "The JWKS endpoint could not be fetched. Check that verify can reach it and that it serves a " +
+			"JSON Web Key Set, or set JWKS_ENDPOINT explicitly."
+	}
+
+	return d
+}
@@ -0,0 +1,263 @@
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+type testSigner struct {
+	key    *ecdsa.PrivateKey
+	keyID  string
+	signer jose.Signer
+}
+
+func newTestSigner(t *testing.T, keyID string) *testSigner {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.ES256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: keyID, Algorithm: string(jose.ES256), Use: "sig"},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	require.NoError(t, err)
+
+	return &testSigner{key: key, keyID: keyID, signer: signer}
+}
+
+func (s *testSigner) JWK() jose.JSONWebKey {
+	return jose.JSONWebKey{Key: s.key.Public(), KeyID: s.keyID, Algorithm: string(jose.ES256), Use: "sig"}
+}
+
+func (s *testSigner) Sign(t *testing.T, claims any) string {
+	t.Helper()
+
+	rawJWT, err := jwt.Signed(s.signer).Claims(claims).CompactSerialize()
+	require.NoError(t, err)
+	return rawJWT
+}
+
+func newTestJWKSHandler(keys ...jose.JSONWebKey) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: keys})
+	})
+}
+
+func newTestJWKSServer(t *testing.T, keys ...jose.JSONWebKey) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(newTestJWKSHandler(keys...))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestServer(t *testing.T, options ...Option) *Server {
+	t.Helper()
+
+	srv := New(options...)
+	srv.initRouter()
+	return srv
+}
+
+func verifyTestJWT(t *testing.T, srv *Server, rawJWT string) (*sdk.Identity, error) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Pomerium-Jwt-Assertion", rawJWT)
+	return srv.getIdentity(r)
+}
+
+func TestDiagnoseJWT(t *testing.T) {
+	now := time.Now()
+	signer := newTestSigner(t, "key-1")
+	otherSigner := newTestSigner(t, "key-1")
+	jwksSrv := newTestJWKSServer(t, signer.JWK())
+	notFoundSrv := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(notFoundSrv.Close)
+	invalidJWKSSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not a jwks"))
+	}))
+	t.Cleanup(invalidJWKSSrv.Close)
+	// the handshake fails because the client doesn't have a certificate
+	mtlsSrv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	mtlsSrv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MaxVersion: tls.VersionTLS12}
+	mtlsSrv.StartTLS()
+	t.Cleanup(mtlsSrv.Close)
+
+	validClaims := jwt.Claims{
+		Issuer:   "authenticate.example.com",
+		Audience: jwt.Audience{"verify.example.com"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Minute)),
+		IssuedAt: jwt.NewNumericDate(now),
+	}
+	expiredClaims := validClaims
+	expiredClaims.Expiry = jwt.NewNumericDate(now.Add(-time.Hour))
+	notYetValidClaims := validClaims
+	notYetValidClaims.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+
+	for _, tc := range []struct {
+		name         string
+		options      []Option
+		rawJWT       string
+		expectedCode jwtDiagnosisCode
+		expected     any
+		actual       any
+	}{
+		{
+			name:         "missing header",
+			expectedCode: jwtDiagnosisCodeMissingHeader,
+		},
+		{
+			name:         "malformed jws",
+			rawJWT:       "not-a-jwt",
+			expectedCode: jwtDiagnosisCodeMalformedJWS,
+		},
+		{
+			name:         "unknown kid",
+			rawJWT:       newTestSigner(t, "key-2").Sign(t, validClaims),
+			expectedCode: jwtDiagnosisCodeUnknownKeyID,
+			actual:       "key-2",
+		},
+		{
+			name:         "bad signature",
+			rawJWT:       otherSigner.Sign(t, validClaims),
+			expectedCode: jwtDiagnosisCodeBadSignature,
+		},
+		{
+			name:         "expired",
+			rawJWT:       signer.Sign(t, expiredClaims),
+			expectedCode: jwtDiagnosisCodeExpired,
+			actual:       expiredClaims.Expiry,
+		},
+		{
+			name:         "not yet valid",
+			rawJWT:       signer.Sign(t, notYetValidClaims),
+			expectedCode: jwtDiagnosisCodeNotYetValid,
+			actual:       notYetValidClaims.NotBefore,
+		},
+		{
+			name:         "issuer mismatch",
+			options:      []Option{WithExpectedJWTIssuer("other.example.com")},
+			rawJWT:       signer.Sign(t, validClaims),
+			expectedCode: jwtDiagnosisCodeIssuerMismatch,
+			expected:     "other.example.com",
+			actual:       "authenticate.example.com",
+		},
+		{
+			name:         "audience mismatch",
+			options:      []Option{WithExpectedJWTAudience("other.example.com")},
+			rawJWT:       signer.Sign(t, validClaims),
+			expectedCode: jwtDiagnosisCodeAudienceMismatch,
+			expected:     "other.example.com",
+			actual:       validClaims.Audience,
+		},
+		{
+			name:         "jwks fetch failure",
+			options:      []Option{WithJWKSEndpoint(notFoundSrv.URL)},
+			rawJWT:       signer.Sign(t, validClaims),
+			expectedCode: jwtDiagnosisCodeJWKSFetchFailed,
+		},
+		{
+			name:         "invalid jwks",
+			options:      []Option{WithJWKSEndpoint(invalidJWKSSrv.URL)},
+			rawJWT:       signer.Sign(t, validClaims),
+			expectedCode: jwtDiagnosisCodeJWKSFetchFailed,
+		},
+		{
+			name:         "jwks tls failure",
+			options:      []Option{WithJWKSEndpoint(mtlsSrv.URL)},
+			rawJWT:       signer.Sign(t, validClaims),
+			expectedCode: jwtDiagnosisCodeJWKSTLSFailed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, append([]Option{WithJWKSEndpoint(jwksSrv.URL)}, tc.options...)...)
+
+			_, err := verifyTestJWT(t, srv, tc.rawJWT)
+			require.Error(t, err)
+
+			if tc.expectedCode == jwtDiagnosisCodeJWKSFetchFailed {
+				assert.ErrorIs(t, err, errJWKSFetch)
+			}
+			d := srv.diagnoseJWT(tc.rawJWT, err, now)
+			assert.Equal(t, tc.expectedCode, d.Code, d.Error)
+			assert.Equal(t, tc.expected, d.Expected)
+			assert.Equal(t, tc.actual, d.Actual)
+			assert.NotEmpty(t, d.Hint)
+		})
+	}
+}
+
+func TestJWTLeeway(t *testing.T) {
+	now := time.Now()
+	signer := newTestSigner(t, "key-1")
+	jwksSrv := newTestJWKSServer(t, signer.JWK())
+
+	sign := func(expiry time.Time) string {
+		return signer.Sign(t, jwt.Claims{
+			Issuer:   "authenticate.example.com",
+			Expiry:   jwt.NewNumericDate(expiry),
+			IssuedAt: jwt.NewNumericDate(expiry.Add(-time.Minute)),
+		})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		options []Option
+		expiry  time.Time
+		valid   bool
+	}{
+		{"default leeway", nil, now.Add(-30 * time.Second), true},
+		{"no leeway", []Option{WithJWTLeeway(0)}, now.Add(-30 * time.Second), false},
+		{"expired beyond default leeway", nil, now.Add(-2 * time.Minute), false},
+		{"large leeway", []Option{WithJWTLeeway(5 * time.Minute)}, now.Add(-2 * time.Minute), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, append([]Option{WithJWKSEndpoint(jwksSrv.URL)}, tc.options...)...)
+			_, err := verifyTestJWT(t, srv, sign(tc.expiry))
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, jwt.ErrExpired)
+			}
+		})
+	}
+
+	t.Run("time report", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL))
+
+		r := httptest.NewRequest(http.MethodGet, "/api/verify-info", nil)
+		r.Header.Set(headerJWTAssertion, sign(now.Add(-30*time.Second)))
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Time TokenTimeReport `json:"time"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, "1m0s", res.Time.Leeway)
+		skew, err := time.ParseDuration(res.Time.Skew)
+		require.NoError(t, err)
+		assert.InDelta(t, 90*time.Second, skew, float64(2*time.Second))
+		assert.True(t, res.Time.Expired)
+		assert.True(t, res.Time.ValidWithLeeway)
+		assert.False(t, res.Time.ValidWithoutLeeway)
+	})
+}
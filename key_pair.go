@@ -0,0 +1,104 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// keyPairWatchInterval is how often certificate and key files are checked for
+// changes.
+const keyPairWatchInterval = jwksFileWatchInterval
+
+// A keyPair is a certificate and key loaded from PEM files, and reloaded when
+// they change.
+type keyPair struct {
+	// name describes what the certificate is used for in logs
+	name     string
+	certPath string
+	keyPath  string
+
+	mu      sync.RWMutex
+	certPEM []byte
+	keyPEM  []byte
+	cert    *tls.Certificate
+	leaf    *x509.Certificate
+}
+
+func newKeyPair(name, certPath, keyPath string) *keyPair {
+	return &keyPair{
+		name:     name,
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+}
+
+// load reads the certificate and key, returning whether they changed. If they
+// are invalid the previous certificate is kept.
+func (c *keyPair) load() (bool, error) {
+	certPEM, err := os.ReadFile(c.certPath)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(c.keyPath)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.RLock()
+	unchanged := c.cert != nil && bytes.Equal(certPEM, c.certPEM) && bytes.Equal(keyPEM, c.keyPEM)
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.certPEM, c.keyPEM, c.cert, c.leaf = certPEM, keyPEM, &cert, leaf
+	c.mu.Unlock()
+	return true, nil
+}
+
+// get returns the current certificate, or nil if none has been loaded.
+func (c *keyPair) get() (*tls.Certificate, *x509.Certificate) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, c.leaf
+}
+
+// watch reloads the certificate whenever it changes, until the context
+// is canceled.
+func (c *keyPair) watch(ctx context.Context) {
+	ticker := time.NewTicker(keyPairWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := c.load()
+		if err != nil {
+			log.Error().Err(err).Str("cert", c.certPath).Msgf("failed to reload %s, keeping previous certificate", c.name)
+		} else if changed {
+			log.Info().Str("cert", c.certPath).Msgf("reloaded %s", c.name)
+		}
+	}
+}
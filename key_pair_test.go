@@ -0,0 +1,72 @@
+package verify
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPairReload(t *testing.T) {
+	ca := newTestCertificate(t, nil, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Client CA"},
+		IsCA:    true,
+	})
+	newClientCert := func(commonName string) *testCA {
+		return newTestCertificate(t, ca, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: commonName},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath := writeTestKeyPair(t, dir, newClientCert("first"))
+	cc := newKeyPair("client certificate", certPath, keyPath)
+	changed, err := cc.load()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, leaf := cc.get()
+	require.NotNil(t, leaf)
+	assert.Equal(t, "first", leaf.Subject.CommonName)
+
+	changed, err = cc.load()
+	assert.NoError(t, err)
+	assert.False(t, changed, "unchanged files should not be reloaded")
+
+	writeTestKeyPair(t, dir, newClientCert("second"))
+	changed, err = cc.load()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	_, leaf = cc.get()
+	assert.Equal(t, "second", leaf.Subject.CommonName)
+
+	require.NoError(t, os.WriteFile(keyPath, []byte("not a key"), 0o600))
+	_, err = cc.load()
+	assert.Error(t, err)
+	_, leaf = cc.get()
+	assert.Equal(t, "second", leaf.Subject.CommonName, "the previous certificate should be kept")
+}
+
+// writeTestKeyPair writes the certificate and its key to PEM files in
+// dir, returning their paths.
+func writeTestKeyPair(t *testing.T, dir string, cert *testCA) (string, string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.key)
+	require.NoError(t, err)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: cert.cert.Raw,
+	}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: keyDER,
+	}), 0o600))
+	return certPath, keyPath
+}
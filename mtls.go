@@ -0,0 +1,93 @@
+package verify
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// client certificate modes when serving HTTPS
+const (
+	tlsClientAuthRequest = "request"
+	tlsClientAuthRequire = "require"
+)
+
+var errNoClientCertificate = errors.New("no client certificate presented")
+
+// An mtlsReport describes the client certificate presented to verify, e.g.
+// Pomerium's tls_client_cert when verify is its upstream.
+type mtlsReport struct {
+	Mode      string `json:"mode"`
+	Presented bool   `json:"presented"`
+	Verified  bool   `json:"verified"`
+	Error     string `json:"error,omitempty"`
+	// URIs are the URI SANs of the leaf, and SPIFFEID the first of them with
+	// the spiffe scheme.
+	URIs     []string `json:"uris,omitempty"`
+	SPIFFEID string   `json:"spiffeId,omitempty"`
+	// Certificates are the certificates presented by the client, leaf first.
+	Certificates []tlsCertificateInfo `json:"certificates"`
+	// VerifiedChain is the chain from the leaf to a client CA cert, which was
+	// loaded from AnchorFile.
+	VerifiedChain []tlsCertificateInfo `json:"verifiedChain,omitempty"`
+	AnchorFile    string               `json:"anchorFile,omitempty"`
+}
+
+// verifyClientCertificate verifies the certificates presented by a client
+// against the client CA certs.
+func (srv *Server) verifyClientCertificate(certs []*x509.Certificate, now time.Time) ([]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errNoClientCertificate
+	}
+
+	roots, _ := srv.clientCAs.get()
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return nil, err
+	}
+	return chains[0], nil
+}
+
+// getMTLSReport returns the report for the client certificate presented on the
+// request's connection, or nil if client certificates aren't requested.
+func (srv *Server) getMTLSReport(r *http.Request) *mtlsReport {
+	if srv.clientCAs == nil || r.TLS == nil {
+		return nil
+	}
+
+	certs := r.TLS.PeerCertificates
+	report := &mtlsReport{
+		Mode:         srv.cfg.tlsClientAuth,
+		Presented:    len(certs) > 0,
+		Certificates: getCertificateInfos(certs),
+	}
+	if report.Presented {
+		for _, u := range certs[0].URIs {
+			report.URIs = append(report.URIs, u.String())
+			if u.Scheme == "spiffe" && report.SPIFFEID == "" {
+				report.SPIFFEID = u.String()
+			}
+		}
+	}
+
+	chain, err := srv.verifyClientCertificate(certs, time.Now())
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Verified = true
+	report.VerifiedChain = getCertificateInfos(chain)
+	_, files := srv.clientCAs.get()
+	report.AnchorFile = files[getCertificateFingerprint(chain[len(chain)-1])]
+	return report
+}
@@ -0,0 +1,145 @@
+package verify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMTLS(t *testing.T) {
+	ca := newTestCertificate(t, nil, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Root CA"},
+		IsCA:    true,
+	})
+	clientCA := newTestCertificate(t, nil, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Client CA"},
+		IsCA:    true,
+	})
+	otherCA := newTestCertificate(t, nil, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Other CA"},
+		IsCA:    true,
+	})
+	spiffeID, err := url.Parse("spiffe://example.com/pomerium")
+	require.NoError(t, err)
+	newClientCert := func(parent *testCA) tls.Certificate {
+		cert := newTestCertificate(t, parent, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "pomerium"},
+			URIs:        []*url.URL{spiffeID},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		return tls.Certificate{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath := writeTestKeyPair(t, dir, newTestCertificate(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "verify"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}))
+	clientCAPath := filepath.Join(dir, "client-ca.pem")
+	require.NoError(t, os.WriteFile(clientCAPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: clientCA.cert.Raw,
+	}), 0o600))
+
+	getVerifyInfo := func(t *testing.T, addr string, clientCerts ...tls.Certificate) (*mtlsReport, error) {
+		t.Helper()
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: clientCerts,
+			},
+		}}
+		res, err := client.Get("https://" + addr + "/api/verify-info")
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var info struct {
+			ClientCertificate *mtlsReport `json:"clientCertificate"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&info))
+		return info.ClientCertificate, nil
+	}
+
+	t.Run("request", func(t *testing.T) {
+		srv := newTestServer(t,
+			WithTLSCertificate(certPath, keyPath),
+			WithTLSClientAuth("request"),
+			WithTLSClientCACerts(clientCAPath))
+		addr := serveTestHTTPS(t, srv)
+
+		t.Run("valid", func(t *testing.T) {
+			report, err := getVerifyInfo(t, addr, newClientCert(clientCA))
+			require.NoError(t, err)
+			require.NotNil(t, report)
+			assert.Equal(t, "request", report.Mode)
+			assert.True(t, report.Presented)
+			assert.True(t, report.Verified, report.Error)
+			assert.Equal(t, spiffeID.String(), report.SPIFFEID)
+			assert.Equal(t, []string{spiffeID.String()}, report.URIs)
+			require.Len(t, report.Certificates, 1)
+			assert.Equal(t, "CN=pomerium", report.Certificates[0].Subject)
+			assert.Equal(t, []string{"URI:" + spiffeID.String()}, report.Certificates[0].SANs)
+			require.Len(t, report.VerifiedChain, 2)
+			assert.Equal(t, getCertificateFingerprint(clientCA.cert), report.VerifiedChain[1].SHA256Fingerprint)
+			assert.Equal(t, clientCAPath, report.AnchorFile)
+		})
+		t.Run("untrusted", func(t *testing.T) {
+			report, err := getVerifyInfo(t, addr, newClientCert(otherCA))
+			require.NoError(t, err, "a requested certificate should only be reported")
+			require.NotNil(t, report)
+			assert.True(t, report.Presented)
+			assert.False(t, report.Verified)
+			assert.Contains(t, report.Error, "unknown authority")
+			assert.Len(t, report.Certificates, 1)
+		})
+		t.Run("missing", func(t *testing.T) {
+			report, err := getVerifyInfo(t, addr)
+			require.NoError(t, err)
+			require.NotNil(t, report)
+			assert.False(t, report.Presented)
+			assert.Equal(t, errNoClientCertificate.Error(), report.Error)
+		})
+	})
+	t.Run("require", func(t *testing.T) {
+		srv := newTestServer(t,
+			WithTLSCertificate(certPath, keyPath),
+			WithTLSClientAuth("require"),
+			WithTLSClientCACerts(clientCAPath))
+		addr := serveTestHTTPS(t, srv)
+
+		report, err := getVerifyInfo(t, addr, newClientCert(clientCA))
+		require.NoError(t, err)
+		require.NotNil(t, report)
+		assert.True(t, report.Verified)
+
+		_, err = getVerifyInfo(t, addr, newClientCert(otherCA))
+		assert.Error(t, err)
+		_, err = getVerifyInfo(t, addr)
+		assert.Error(t, err)
+	})
+	t.Run("not requested", func(t *testing.T) {
+		srv := newTestServer(t, WithTLSCertificate(certPath, keyPath))
+		addr := serveTestHTTPS(t, srv)
+
+		report, err := getVerifyInfo(t, addr, newClientCert(clientCA))
+		require.NoError(t, err)
+		assert.Nil(t, report, "client certificates should only be reported when requested")
+	})
+}
@@ -0,0 +1,169 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+const (
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	// oidcDiscoveryCacheTTL is how long a discovery document is cached
+	oidcDiscoveryCacheTTL = time.Hour
+	// oidcDiscoveryErrorTTL is how long a failed discovery is remembered, so
+	// that a broken issuer isn't fetched on every request
+	oidcDiscoveryErrorTTL    = minJWKSRefetchInterval
+	maxOIDCDiscoveryBodySize = 1024 * 1024
+)
+
+// where the JWKS endpoint came from
+const (
+	jwksEndpointSourceStatic        = "static"
+	jwksEndpointSourceConfig        = "config"
+	jwksEndpointSourceOIDCDiscovery = "oidc_discovery"
+	jwksEndpointSourceDefault       = "pomerium_default"
+)
+
+// A jwksEndpointInfo describes the JWKS endpoint used for an issuer.
+type jwksEndpointInfo struct {
+	URL    string `json:"url"`
+	Source string `json:"source"`
+	// DiscoveryURL is the OIDC discovery document the URL was resolved from.
+	DiscoveryURL string `json:"discoveryUrl,omitempty"`
+	// Error is set if OIDC discovery failed.
+	Error string `json:"error,omitempty"`
+}
+
+type oidcDiscoveryEntry struct {
+	jwksURI   string
+	err       error
+	expiresAt time.Time
+}
+
+// An oidcDiscovery resolves the JWKS endpoint of a single issuer from its OIDC
+// discovery document. The issuer is configured rather than taken from the
+// token, so that tokens can't make verify fetch arbitrary URLs.
+type oidcDiscovery struct {
+	issuer       string
+	discoveryURL string
+	client       *http.Client
+
+	mu    sync.Mutex
+	entry *oidcDiscoveryEntry
+}
+
+// newOIDCDiscovery creates a new oidcDiscovery for the issuer. If
+// discoveryURL is empty the issuer's well-known discovery URL is used.
+func newOIDCDiscovery(issuer, discoveryURL string, tlsVerifier *tlsVerifier) *oidcDiscovery {
+	if discoveryURL == "" {
+		discoveryURL = getOIDCDiscoveryURL(issuer)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialTLSContext = tlsVerifier.DialTLSContext
+	return &oidcDiscovery{
+		issuer:       issuer,
+		discoveryURL: discoveryURL,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   maxRemoteWait,
+		},
+	}
+}
+
+// getOIDCDiscoveryURL returns the well-known discovery document URL for an
+// issuer.
+func getOIDCDiscoveryURL(issuer string) string {
+	rawURL := issuer
+	if !strings.HasPrefix(rawURL, "https://") && !strings.HasPrefix(rawURL, "http://") {
+		rawURL = "https://" + rawURL
+	}
+	return strings.TrimSuffix(rawURL, "/") + oidcDiscoveryPath
+}
+
+// matchesIssuer returns whether discovery is done for the issuer.
+func (d *oidcDiscovery) matchesIssuer(issuer string) bool {
+	return normalizeIssuer(issuer) == normalizeIssuer(d.issuer)
+}
+
+// getCached returns the cached discovery result.
+func (d *oidcDiscovery) getCached() (*oidcDiscoveryEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry := d.entry
+	if entry == nil || !time.Now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// resolve returns the jwks_uri from the discovery document, fetching it if
+// it isn't cached.
+func (d *oidcDiscovery) resolve(ctx context.Context) (string, error) {
+	if entry, ok := d.getCached(); ok {
+		return entry.jwksURI, entry.err
+	}
+
+	jwksURI, err := d.fetch(ctx)
+	entry := &oidcDiscoveryEntry{jwksURI: jwksURI, err: err}
+	if err != nil {
+		entry.expiresAt = time.Now().Add(oidcDiscoveryErrorTTL)
+	} else {
+		entry.expiresAt = time.Now().Add(oidcDiscoveryCacheTTL)
+	}
+
+	d.mu.Lock()
+	d.entry = entry
+	d.mu.Unlock()
+
+	return jwksURI, err
+}
+
+func (d *oidcDiscovery) fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.discoveryURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: unexpected status code from OIDC discovery endpoint: %d",
+			sdk.ErrJWKSNotFound, res.StatusCode)
+	}
+
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	err = json.NewDecoder(io.LimitReader(res.Body, maxOIDCDiscoveryBodySize)).Decode(&doc)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid OIDC discovery document: %v", sdk.ErrJWKSNotFound, err)
+	}
+	// OIDC discovery requires the document to be for the issuer it was
+	// fetched for
+	if !d.matchesIssuer(doc.Issuer) {
+		return "", fmt.Errorf("%w: OIDC discovery document is for issuer %q, expected %q",
+			sdk.ErrJWKSNotFound, doc.Issuer, d.issuer)
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("%w: OIDC discovery document has no jwks_uri", sdk.ErrJWKSNotFound)
+	}
+	if _, err := url.Parse(doc.JWKSURI); err != nil {
+		return "", fmt.Errorf("%w: invalid jwks_uri in OIDC discovery document: %v", sdk.ErrJWKSNotFound, err)
+	}
+	return doc.JWKSURI, nil
+}
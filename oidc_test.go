@@ -0,0 +1,155 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+func TestOIDCDiscovery(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	jwksSrv := newTestJWKSServer(t, signer.JWK())
+
+	var discoveryFetches atomic.Int32
+	// newDiscoveryServer serves the document, with the server's URL as the
+	// issuer unless it sets one
+	newDiscoveryServer := func(t *testing.T, doc map[string]any) *httptest.Server {
+		t.Helper()
+
+		var srv *httptest.Server
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != oidcDiscoveryPath {
+				http.NotFound(w, r)
+				return
+			}
+			discoveryFetches.Add(1)
+			res := map[string]any{"issuer": srv.URL}
+			for k, v := range doc {
+				res[k] = v
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(res)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	sign := func(issuer string) string {
+		return signer.Sign(t, jwt.Claims{
+			Issuer: issuer,
+			Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		})
+	}
+
+	t.Run("issuer", func(t *testing.T) {
+		discoveryFetches.Store(0)
+		issuerSrv := newDiscoveryServer(t, map[string]any{"jwks_uri": jwksSrv.URL})
+		srv := newTestServer(t, WithOIDCDiscovery(true), WithExpectedJWTIssuer(issuerSrv.URL))
+
+		for i := 0; i < 2; i++ {
+			identity, err := verifyTestJWT(t, srv, sign(issuerSrv.URL))
+			require.NoError(t, err)
+			assert.Equal(t, issuerSrv.URL, identity.Issuer)
+		}
+		assert.Equal(t, int32(1), discoveryFetches.Load(), "should cache the discovery document")
+
+		assert.Equal(t, jwksEndpointInfo{
+			URL:          jwksSrv.URL,
+			Source:       jwksEndpointSourceOIDCDiscovery,
+			DiscoveryURL: issuerSrv.URL + oidcDiscoveryPath,
+		}, srv.defaultVerifier.getJWKSEndpointInfo(issuerSrv.URL))
+	})
+	t.Run("discovery url", func(t *testing.T) {
+		discoverySrv := newDiscoveryServer(t, map[string]any{
+			"issuer":   "https://idp.example.com",
+			"jwks_uri": jwksSrv.URL,
+		})
+		srv := newTestServer(t,
+			WithOIDCDiscoveryURL(discoverySrv.URL+oidcDiscoveryPath),
+			WithExpectedJWTIssuer("https://idp.example.com"),
+		)
+
+		_, err := verifyTestJWT(t, srv, sign("https://idp.example.com"))
+		assert.NoError(t, err)
+	})
+	t.Run("missing jwks_uri", func(t *testing.T) {
+		issuerSrv := newDiscoveryServer(t, map[string]any{})
+		srv := newTestServer(t, WithOIDCDiscovery(true), WithExpectedJWTIssuer(issuerSrv.URL))
+
+		rawJWT := sign(issuerSrv.URL)
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		require.Error(t, err)
+		d := srv.diagnoseJWT(rawJWT, err, time.Now())
+		assert.Equal(t, jwtDiagnosisCodeJWKSFetchFailed, d.Code)
+
+		info := srv.defaultVerifier.getJWKSEndpointInfo(issuerSrv.URL)
+		assert.Empty(t, info.URL)
+		assert.Contains(t, info.Error, "no jwks_uri")
+	})
+	t.Run("configured endpoint", func(t *testing.T) {
+		srv := newTestServer(t, WithOIDCDiscovery(true), WithJWKSEndpoint(jwksSrv.URL))
+
+		assert.Equal(t, jwksEndpointInfo{
+			URL:    jwksSrv.URL,
+			Source: jwksEndpointSourceConfig,
+		}, srv.defaultVerifier.getJWKSEndpointInfo("https://idp.example.com"))
+	})
+	t.Run("verify info", func(t *testing.T) {
+		issuerSrv := newDiscoveryServer(t, map[string]any{"jwks_uri": jwksSrv.URL})
+		srv := newTestServer(t, WithOIDCDiscovery(true), WithExpectedJWTIssuer(issuerSrv.URL))
+
+		r := httptest.NewRequest(http.MethodGet, "/api/verify-info", nil)
+		r.Header.Set(headerJWTAssertion, sign(issuerSrv.URL))
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Error        string           `json:"error"`
+			JWKSEndpoint jwksEndpointInfo `json:"jwksEndpoint"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Empty(t, res.Error)
+		assert.Equal(t, jwksSrv.URL, res.JWKSEndpoint.URL)
+		assert.Equal(t, jwksEndpointSourceOIDCDiscovery, res.JWKSEndpoint.Source)
+	})
+	t.Run("issuer mismatch", func(t *testing.T) {
+		discoveryFetches.Store(0)
+		issuerSrv := newDiscoveryServer(t, map[string]any{"jwks_uri": jwksSrv.URL})
+		otherSrv := newDiscoveryServer(t, map[string]any{"jwks_uri": jwksSrv.URL})
+		srv := newTestServer(t, WithOIDCDiscovery(true), WithExpectedJWTIssuer(issuerSrv.URL))
+
+		rawJWT := sign(otherSrv.URL)
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		assert.ErrorIs(t, err, jwt.ErrInvalidIssuer)
+		assert.Equal(t, int32(0), discoveryFetches.Load(), "other issuers shouldn't be discovered")
+		assert.Equal(t, jwtDiagnosisCodeIssuerMismatch, srv.diagnoseJWT(rawJWT, err, time.Now()).Code)
+	})
+	t.Run("document issuer mismatch", func(t *testing.T) {
+		issuerSrv := newDiscoveryServer(t, map[string]any{
+			"issuer":   "https://idp.example.com",
+			"jwks_uri": jwksSrv.URL,
+		})
+		srv := newTestServer(t, WithOIDCDiscovery(true), WithExpectedJWTIssuer(issuerSrv.URL))
+
+		_, err := verifyTestJWT(t, srv, sign(issuerSrv.URL))
+		assert.ErrorIs(t, err, sdk.ErrJWKSNotFound)
+		assert.Contains(t, srv.defaultVerifier.getJWKSEndpointInfo(issuerSrv.URL).Error, "idp.example.com")
+	})
+	t.Run("no issuer", func(t *testing.T) {
+		_, err := newVerifierServer(getConfig(WithOIDCDiscovery(true)))
+		assert.Error(t, err)
+		_, err = newVerifierServer(getConfig(WithOIDCDiscoveryURL("https://idp.example.com" + oidcDiscoveryPath)))
+		assert.Error(t, err)
+		_, err = newVerifierServer(getConfig(WithOIDCDiscovery(true), WithTrustedIssuer("idp.example.com", "")))
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,63 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errSPKIPinMismatch indicates no certificate in a TLS chain matched the
+// server's pinned public keys.
+var errSPKIPinMismatch = errors.New("x509: no certificate matches the pinned public keys")
+
+const spkiPinPrefix = "sha256/"
+
+// A tlsPinReport describes the result of checking a chain against the pinned
+// public keys for a server.
+type tlsPinReport struct {
+	Pins    []string `json:"pins"`
+	Matched bool     `json:"matched"`
+	// MatchedPin and MatchedSubject identify the pin that matched and the
+	// certificate it matched.
+	MatchedPin     string `json:"matchedPin,omitempty"`
+	MatchedSubject string `json:"matchedSubject,omitempty"`
+}
+
+// parseSPKIPin returns the base64 encoded SHA-256 hash of a pin, which may be
+// prefixed with "sha256/".
+func parseSPKIPin(pin string) (string, error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), spkiPinPrefix)
+	bs, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(bs) != sha256.Size {
+		return "", fmt.Errorf("invalid SPKI pin %q (expected base64 encoded SHA-256 hash)", pin)
+	}
+	return pin, nil
+}
+
+// getSPKIHash returns the base64 encoded SHA-256 hash of the certificate's
+// SubjectPublicKeyInfo.
+func getSPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// checkSPKIPins returns the first certificate in the chain that matches one of
+// the pins, or an error if none do.
+func checkSPKIPins(pins []string, chain []*x509.Certificate) (*tlsPinReport, error) {
+	report := &tlsPinReport{Pins: pins}
+	for _, cert := range chain {
+		hash := getSPKIHash(cert)
+		for _, pin := range pins {
+			if pin == hash {
+				report.Matched = true
+				report.MatchedPin = pin
+				report.MatchedSubject = cert.Subject.String()
+				return report, nil
+			}
+		}
+	}
+	return report, errSPKIPinMismatch
+}
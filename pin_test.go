@@ -0,0 +1,105 @@
+package verify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSPKIPin(t *testing.T) {
+	pin := "YLh1dUR9y6Kja30RrAn7JKnbQG/uEtLMkBgFF2Fuihg="
+	for _, raw := range []string{pin, "sha256/" + pin, " " + pin + " "} {
+		actual, err := parseSPKIPin(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, pin, actual)
+	}
+	for _, raw := range []string{"", "not base64!", "sha256/AAAA"} {
+		_, err := parseSPKIPin(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestSPKIPinning(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	rawJWT := signer.Sign(t, jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+
+	root := newTestCertificate(t, nil, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Root CA"},
+		IsCA:    true,
+	})
+	intermediate := newTestCertificate(t, root, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Intermediate CA"},
+		IsCA:    true,
+	})
+	leaf := newTestCertificate(t, intermediate, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	rootPath := filepath.Join(t.TempDir(), "root.pem")
+	require.NoError(t, os.WriteFile(rootPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: root.cert.Raw,
+	}), 0o600))
+
+	jwksSrv := httptest.NewUnstartedServer(newTestJWKSHandler(signer.JWK()))
+	jwksSrv.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.cert.Raw, intermediate.cert.Raw},
+		PrivateKey:  leaf.key,
+	}}}
+	jwksSrv.StartTLS()
+	t.Cleanup(jwksSrv.Close)
+
+	verify := func(t *testing.T, options ...Option) (*tlsReport, error) {
+		t.Helper()
+
+		srv := newTestServer(t, append([]Option{WithJWKSEndpoint(jwksSrv.URL)}, options...)...)
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		report := srv.defaultVerifier.tlsVerifier.GetTLSReport("127.0.0.1")
+		require.NotNil(t, report)
+		require.NotNil(t, report.Pin)
+		return report, err
+	}
+
+	t.Run("intermediate pinned", func(t *testing.T) {
+		report, err := verify(t, WithExtraCACerts(rootPath), WithSPKIPin("127.0.0.1", "sha256/"+getSPKIHash(intermediate.cert)))
+		require.NoError(t, err)
+		assert.True(t, report.Verified)
+		assert.True(t, report.Pin.Matched)
+		assert.Equal(t, getSPKIHash(intermediate.cert), report.Pin.MatchedPin)
+		assert.Equal(t, "CN=Test Intermediate CA", report.Pin.MatchedSubject)
+	})
+	t.Run("mismatch", func(t *testing.T) {
+		other := newTestCertificate(t, nil, &x509.Certificate{
+			Subject: pkix.Name{CommonName: "Interception Proxy CA"},
+			IsCA:    true,
+		})
+		report, err := verify(t, WithExtraCACerts(rootPath), WithSPKIPin("127.0.0.1", getSPKIHash(other.cert)))
+		require.Error(t, err, "pins should be enforced without strict TLS")
+		assert.True(t, isTLSError(err))
+		assert.False(t, report.Verified)
+		assert.False(t, report.Pin.Matched)
+		assert.Contains(t, report.Error, "pinned")
+	})
+	t.Run("unverified chain", func(t *testing.T) {
+		_, err := verify(t, WithSPKIPin("127.0.0.1", getSPKIHash(intermediate.cert)))
+		assert.Error(t, err, "only the leaf should be checked when the chain isn't verified")
+
+		report, err := verify(t, WithSPKIPin("127.0.0.1", getSPKIHash(leaf.cert)))
+		assert.NoError(t, err)
+		assert.True(t, report.Pin.Matched)
+	})
+}
@@ -0,0 +1,178 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/rs/zerolog/log"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+var errProbeHostNotAllowed = errors.New("host is not in the probe allowlist")
+
+// A probeReport is the result of probing an upstream host.
+type probeReport struct {
+	Host  string     `json:"host"`
+	TLS   *tlsReport `json:"tls,omitempty"`
+	Error string     `json:"error,omitempty"`
+	// JWKS is set when the host's keys were requested.
+	JWKS *probeJWKSReport `json:"jwks,omitempty"`
+}
+
+// A probeJWKSReport is the result of fetching a probed host's keys.
+type probeJWKSReport struct {
+	URL   string        `json:"url"`
+	Keys  []jwksKeyInfo `json:"keys"`
+	Error string        `json:"error,omitempty"`
+}
+
+// normalizeProbeHost returns the host and port of a probe target, defaulting
+// to port 443.
+func normalizeProbeHost(host string) (string, error) {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return "", fmt.Errorf("host is required")
+	}
+	if strings.Contains(host, "/") {
+		return "", fmt.Errorf("invalid host %q (expected host or host:port)", host)
+	}
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return "", fmt.Errorf("invalid port in host %q", host)
+		}
+		return net.JoinHostPort(h, port), nil
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "443"), nil
+}
+
+// getProbeAddr returns the address to dial for the host, or an error if the
+// host is not allowed.
+func (srv *Server) getProbeAddr(host string) (string, error) {
+	addr, err := normalizeProbeHost(host)
+	if err != nil {
+		return "", err
+	}
+	for _, allowed := range srv.cfg.probeHosts {
+		if allowedAddr, err := normalizeProbeHost(allowed); err == nil && allowedAddr == addr {
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", errProbeHostNotAllowed, host)
+}
+
+// getProbeIssuer returns the issuer served by the address, without the
+// default port.
+func getProbeIssuer(addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+	if port != "443" {
+		return addr
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// getProbeIssuerVerifier returns the verifier for the trusted issuer served by
+// the address, so that its CA certs are used, or the default verifier.
+func (srv *Server) getProbeIssuerVerifier(addr string) *issuerVerifier {
+	if iv, ok := srv.issuerVerifiers[normalizeIssuer(getProbeIssuer(addr))]; ok {
+		return iv
+	}
+	return srv.defaultVerifier
+}
+
+// getProbeJWKSEndpoint returns the JWKS endpoint used to verify tokens issued
+// by the address. The default verifier accepts any issuer, so its configured
+// endpoint is only used when it is served by the address.
+func (srv *Server) getProbeJWKSEndpoint(ctx context.Context, iv *issuerVerifier, addr string) (string, error) {
+	if iv != srv.defaultVerifier {
+		return iv.resolveJWKSEndpoint(ctx, iv.issuer)
+	}
+
+	issuer := getProbeIssuer(addr)
+	switch {
+	case iv.staticJWKS != nil:
+	case iv.jwksEndpoint != "":
+		if u, err := url.Parse(iv.jwksEndpoint); err == nil {
+			if endpointAddr, err := normalizeProbeHost(u.Host); err == nil && endpointAddr == addr {
+				return iv.jwksEndpoint, nil
+			}
+		}
+	case iv.discovery != nil && !iv.discovery.matchesIssuer(issuer):
+	default:
+		// the host's well-known endpoint, or the one in its OIDC discovery
+		// document
+		return iv.resolveJWKSEndpoint(ctx, "https://"+issuer)
+	}
+	u := url.URL{Scheme: "https", Host: issuer, Path: defaultJWKSPath}
+	return u.String(), nil
+}
+
+// serveAPIProbe dials an allowlisted host, reporting its certificate chain and
+// optionally its keys. It requires a verified JWT assertion.
+func (srv *Server) serveAPIProbe(w http.ResponseWriter, r *http.Request) {
+	identity, err := sdk.FromContext(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	addr, err := srv.getProbeAddr(r.FormValue("host"))
+	if errors.Is(err, errProbeHostNotAllowed) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fetchJWKS, _ := strconv.ParseBool(r.FormValue("jwks"))
+
+	log.Info().
+		Str("user", identity.Email).
+		Str("addr", addr).
+		Bool("jwks", fetchJWKS).
+		Msg("probing host")
+
+	iv := srv.getProbeIssuerVerifier(addr)
+	// probe with a verifier of its own, built from the same options, so that
+	// probes don't replace the reports and history of the connections used to
+	// fetch keys
+	tlsVerifier := newTLSVerifier(iv.tlsVerifier.tlsVerifierOptions)
+	report := &probeReport{Host: addr}
+	conn, err := tlsVerifier.DialTLSContext(r.Context(), "tcp", addr)
+	if err == nil {
+		_ = conn.Close()
+	} else {
+		report.Error = err.Error()
+	}
+	report.TLS = tlsVerifier.GetTLSReport(tlsHost(addr))
+
+	if fetchJWKS {
+		report.JWKS = &probeJWKSReport{Keys: []jwksKeyInfo{}}
+		report.JWKS.URL, err = srv.getProbeJWKSEndpoint(r.Context(), iv, addr)
+		var jwks *jose.JSONWebKeySet
+		if err == nil {
+			jwks, err = loadJWKS(r.Context(), tlsVerifier, report.JWKS.URL)
+		}
+		if err == nil {
+			for i := range jwks.Keys {
+				report.JWKS.Keys = append(report.JWKS.Keys, getJWKInfo(&jwks.Keys[i]))
+			}
+		} else {
+			report.JWKS.Error = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,140 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeProbeHost(t *testing.T) {
+	for _, tc := range []struct {
+		host   string
+		expect string
+		err    bool
+	}{
+		{"authenticate.example.com", "authenticate.example.com:443", false},
+		{"Authenticate.Example.com:8443", "authenticate.example.com:8443", false},
+		{"[::1]", "[::1]:443", false},
+		{"[::1]:8443", "[::1]:8443", false},
+		{"", "", true},
+		{"https://authenticate.example.com", "", true},
+		{"authenticate.example.com:http", "", true},
+	} {
+		addr, err := normalizeProbeHost(tc.host)
+		if tc.err {
+			assert.Error(t, err, tc.host)
+		} else {
+			assert.NoError(t, err, tc.host)
+			assert.Equal(t, tc.expect, addr)
+		}
+	}
+}
+
+func TestServeAPIProbe(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	jwksSrv := newTestJWKSServer(t, signer.JWK())
+	upstreamSigner := newTestSigner(t, "upstream")
+	upstreamSrv := httptest.NewTLSServer(newTestJWKSHandler(upstreamSigner.JWK()))
+	t.Cleanup(upstreamSrv.Close)
+	upstreamURL, err := url.Parse(upstreamSrv.URL)
+	require.NoError(t, err)
+
+	srv := newTestServer(t,
+		WithJWKSEndpoint(jwksSrv.URL),
+		WithProbeHost(upstreamURL.Host),
+	)
+	rawJWT := signer.Sign(t, jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+
+	probe := func(t *testing.T, query string, authorized bool) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/api/probe?"+query, nil)
+		if authorized {
+			r.Header.Set(headerJWTAssertion, rawJWT)
+		}
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		w := probe(t, "host="+upstreamURL.Host, false)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+	t.Run("not allowed", func(t *testing.T) {
+		w := probe(t, "host=authenticate.example.com", true)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	t.Run("tls and jwks", func(t *testing.T) {
+		w := probe(t, "host="+upstreamURL.Host+"&jwks=true", true)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var res probeReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, upstreamURL.Host, res.Host)
+		assert.Empty(t, res.Error)
+		require.NotNil(t, res.TLS)
+		assert.False(t, res.TLS.Verified)
+		assert.Len(t, res.TLS.Certificates, 1)
+		require.NotNil(t, res.JWKS)
+		assert.Equal(t, upstreamSrv.URL+defaultJWKSPath, res.JWKS.URL)
+		assert.Empty(t, res.JWKS.Error)
+		require.Len(t, res.JWKS.Keys, 1)
+		assert.Equal(t, "upstream", res.JWKS.Keys[0].KeyID)
+
+		assert.Nil(t, srv.defaultVerifier.tlsVerifier.GetTLSReport(upstreamURL.Hostname()),
+			"probes shouldn't replace the reports of key fetches")
+		assert.Empty(t, srv.getTLSHistory(""), "probes shouldn't be recorded in the TLS history")
+	})
+	t.Run("trusted issuer", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.Handle("/oauth2/keys", newTestJWKSHandler(upstreamSigner.JWK()))
+		issuerSrv := httptest.NewTLSServer(mux)
+		t.Cleanup(issuerSrv.Close)
+		issuerURL, err := url.Parse(issuerSrv.URL)
+		require.NoError(t, err)
+
+		srv := newTestServer(t,
+			WithProbeHost(issuerURL.Host),
+			WithTrustedIssuer("authenticate.example.com", jwksSrv.URL),
+			WithTrustedIssuer(issuerURL.Host, issuerSrv.URL+"/oauth2/keys"),
+		)
+		r := httptest.NewRequest(http.MethodGet, "/api/probe?host="+issuerURL.Host+"&jwks=true", nil)
+		r.Header.Set(headerJWTAssertion, rawJWT)
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var res probeReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.NotNil(t, res.JWKS)
+		assert.Equal(t, issuerSrv.URL+"/oauth2/keys", res.JWKS.URL)
+		assert.Empty(t, res.JWKS.Error)
+		require.Len(t, res.JWKS.Keys, 1)
+		assert.Equal(t, "upstream", res.JWKS.Keys[0].KeyID)
+	})
+}
+
+func TestGetProbeIssuerVerifier(t *testing.T) {
+	srv := newTestServer(t,
+		WithTrustedIssuer("https://Authenticate.Example.com/", "https://keys.example.com/jwks.json"),
+		WithTrustedIssuer("authenticate.example.com:8443", "https://keys.example.com/jwks.json"),
+	)
+
+	iv := srv.getProbeIssuerVerifier("authenticate.example.com:443")
+	assert.Equal(t, "https://Authenticate.Example.com/", iv.issuer)
+	iv = srv.getProbeIssuerVerifier("authenticate.example.com:8443")
+	assert.Equal(t, "authenticate.example.com:8443", iv.issuer)
+	iv = srv.getProbeIssuerVerifier("other.example.com:443")
+	assert.Equal(t, srv.defaultVerifier, iv)
+}
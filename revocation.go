@@ -0,0 +1,416 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// revocationCacheTTL is how long OCSP responses and CRLs without a next
+	// update time are cached
+	revocationCacheTTL     = time.Hour
+	maxRevocationBodySize  = 10 * 1024 * 1024
+	ocspRequestContentType = "application/ocsp-request"
+	// maxRevocationWait bounds all the revocation checks of a chain, which
+	// run during the TLS handshake, so that the handshake can still complete
+	// within maxRemoteWait
+	maxRevocationWait = 3 * time.Second
+	// crlFileWatchInterval is how often CRL files are checked for changes
+	crlFileWatchInterval = jwksFileWatchInterval
+)
+
+// errCertificateRevoked indicates a certificate in a TLS chain was revoked.
+var errCertificateRevoked = errors.New("x509: certificate has been revoked")
+
+// revocation statuses
+const (
+	revocationStatusGood    = "good"
+	revocationStatusRevoked = "revoked"
+	revocationStatusUnknown = "unknown"
+)
+
+// where a revocation status came from
+const (
+	revocationSourceOCSPStapled = "ocsp_stapled"
+	revocationSourceOCSP        = "ocsp"
+	revocationSourceCRL         = "crl"
+	revocationSourceCRLFile     = "crl_file"
+)
+
+// A tlsRevocationStatus is the revocation status of a certificate in a
+// verified chain.
+type tlsRevocationStatus struct {
+	Subject string `json:"subject"`
+	Status  string `json:"status"`
+	Source  string `json:"source,omitempty"`
+	// URL is the OCSP responder or CRL distribution point, or the path of a
+	// CRL file.
+	URL       string     `json:"url,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	// Error is set when the status is unknown.
+	Error string `json:"error,omitempty"`
+}
+
+type crlFile struct {
+	path string
+	raw  []byte
+	crl  *x509.RevocationList
+}
+
+type revocationCacheEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// A revocationChecker checks whether certificates have been revoked, using
+// stapled OCSP responses, local CRL files, OCSP responders and CRL
+// distribution points, in that order.
+type revocationChecker struct {
+	client   *http.Client
+	crlPaths []string
+	timeout  time.Duration
+
+	crlMu    sync.RWMutex
+	crlFiles []crlFile
+
+	mu    sync.Mutex
+	cache map[string]*revocationCacheEntry
+}
+
+func newRevocationChecker(crlPaths []string) (*revocationChecker, error) {
+	c := &revocationChecker{
+		client:   &http.Client{Timeout: maxRemoteWait},
+		crlPaths: crlPaths,
+		timeout:  maxRevocationWait,
+		cache:    make(map[string]*revocationCacheEntry),
+	}
+	if _, err := c.loadCRLFiles(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadCRLFiles reads the CRL files, returning whether any of them changed. A
+// file that can't be read or parsed keeps its previous CRL, if any.
+func (c *revocationChecker) loadCRLFiles() (bool, error) {
+	c.crlMu.RLock()
+	previous := c.crlFiles
+	c.crlMu.RUnlock()
+
+	var errs []error
+	changed := false
+	files := make([]crlFile, 0, len(c.crlPaths))
+	for i, path := range c.crlPaths {
+		var prev *crlFile
+		if i < len(previous) {
+			prev = &previous[i]
+		}
+
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			err = fmt.Errorf("failed to read CRL file: %w", err)
+		} else if prev != nil && bytes.Equal(bs, prev.raw) {
+			files = append(files, *prev)
+			continue
+		}
+		var crl *x509.RevocationList
+		if err == nil {
+			crl, err = parseCRL(bs)
+			if err != nil {
+				err = fmt.Errorf("invalid CRL file %s: %w", path, err)
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+			if prev != nil {
+				files = append(files, *prev)
+			}
+			continue
+		}
+		files = append(files, crlFile{path: path, raw: bs, crl: crl})
+		changed = true
+	}
+	if changed {
+		c.crlMu.Lock()
+		c.crlFiles = files
+		c.crlMu.Unlock()
+	}
+	return changed, errors.Join(errs...)
+}
+
+// getCRLFiles returns the currently loaded CRL files.
+func (c *revocationChecker) getCRLFiles() []crlFile {
+	c.crlMu.RLock()
+	defer c.crlMu.RUnlock()
+
+	return c.crlFiles
+}
+
+// watch reloads the CRL files whenever they change, until the context is
+// canceled.
+func (c *revocationChecker) watch(ctx context.Context) {
+	ticker := time.NewTicker(crlFileWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := c.loadCRLFiles()
+		if err != nil {
+			log.Error().Err(err).Strs("paths", c.crlPaths).Msg("failed to reload CRL files, keeping previous CRLs")
+		}
+		if changed {
+			log.Info().Strs("paths", c.crlPaths).Msg("reloaded CRL files")
+		}
+	}
+}
+
+// check returns the revocation status of every certificate in the chain
+// except the root. The stapled OCSP response, if any, is for the leaf. The
+// checks share a single deadline, after which the remaining statuses are
+// unknown.
+func (c *revocationChecker) check(ctx context.Context, chain []*x509.Certificate, stapled []byte) []tlsRevocationStatus {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var statuses []tlsRevocationStatus
+	for i := 0; i+1 < len(chain); i++ {
+		if i > 0 {
+			stapled = nil
+		}
+		statuses = append(statuses, c.checkCertificate(ctx, chain[i], chain[i+1], stapled))
+	}
+	return statuses
+}
+
+func (c *revocationChecker) checkCertificate(ctx context.Context, cert, issuer *x509.Certificate, stapled []byte) tlsRevocationStatus {
+	var errs []string
+	try := func(status tlsRevocationStatus, err error) (tlsRevocationStatus, bool) {
+		if err != nil {
+			errs = append(errs, err.Error())
+			return status, false
+		}
+		status.Subject = cert.Subject.String()
+		return status, true
+	}
+
+	if len(stapled) > 0 {
+		if status, ok := try(checkOCSPResponse(stapled, cert, issuer, revocationSourceOCSPStapled, "")); ok {
+			return status
+		}
+	}
+	for _, f := range c.getCRLFiles() {
+		if !bytes.Equal(f.crl.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		if status, ok := try(checkCRL(f.crl, cert, issuer, revocationSourceCRLFile, f.path)); ok {
+			return status
+		}
+	}
+	for _, u := range cert.OCSPServer {
+		if status, ok := try(c.checkOCSP(ctx, u, cert, issuer)); ok {
+			return status
+		}
+	}
+	for _, u := range cert.CRLDistributionPoints {
+		if status, ok := try(c.checkCRLDistributionPoint(ctx, u, cert, issuer)); ok {
+			return status
+		}
+	}
+
+	status := tlsRevocationStatus{
+		Subject: cert.Subject.String(),
+		Status:  revocationStatusUnknown,
+		Error:   "no revocation information available",
+	}
+	if len(errs) > 0 {
+		status.Error = strings.Join(errs, "; ")
+	}
+	return status
+}
+
+func (c *revocationChecker) checkOCSP(ctx context.Context, responderURL string, cert, issuer *x509.Certificate) (tlsRevocationStatus, error) {
+	key := "ocsp|" + responderURL + "|" + getCertificateFingerprint(cert)
+	bs, ok := c.getCached(key)
+	if !ok {
+		req, err := ocsp.CreateRequest(cert, issuer, nil)
+		if err != nil {
+			return tlsRevocationStatus{}, err
+		}
+		bs, err = c.fetch(ctx, http.MethodPost, responderURL, req)
+		if err != nil {
+			return tlsRevocationStatus{}, fmt.Errorf("OCSP request to %s failed: %w", responderURL, err)
+		}
+	}
+
+	status, err := checkOCSPResponse(bs, cert, issuer, revocationSourceOCSP, responderURL)
+	if err == nil && !ok {
+		res, _ := ocsp.ParseResponseForCert(bs, cert, issuer)
+		c.setCached(key, bs, res.NextUpdate)
+	}
+	return status, err
+}
+
+func (c *revocationChecker) checkCRLDistributionPoint(ctx context.Context, crlURL string, cert, issuer *x509.Certificate) (tlsRevocationStatus, error) {
+	key := "crl|" + crlURL
+	bs, ok := c.getCached(key)
+	if !ok {
+		var err error
+		bs, err = c.fetch(ctx, http.MethodGet, crlURL, nil)
+		if err != nil {
+			return tlsRevocationStatus{}, fmt.Errorf("CRL request to %s failed: %w", crlURL, err)
+		}
+	}
+
+	crl, err := parseCRL(bs)
+	if err != nil {
+		return tlsRevocationStatus{}, fmt.Errorf("invalid CRL from %s: %w", crlURL, err)
+	}
+	status, err := checkCRL(crl, cert, issuer, revocationSourceCRL, crlURL)
+	if err == nil && !ok {
+		c.setCached(key, bs, crl.NextUpdate)
+	}
+	return status, err
+}
+
+func (c *revocationChecker) fetch(ctx context.Context, method, rawURL string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", ocspRequestContentType)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, maxRevocationBodySize))
+}
+
+func (c *revocationChecker) getCached(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+func (c *revocationChecker) setCached(key string, data []byte, nextUpdate time.Time) {
+	expiresAt := time.Now().Add(revocationCacheTTL)
+	if !nextUpdate.IsZero() && nextUpdate.Before(expiresAt) {
+		expiresAt = nextUpdate
+	}
+
+	c.mu.Lock()
+	c.cache[key] = &revocationCacheEntry{data: data, expiresAt: expiresAt}
+	c.mu.Unlock()
+}
+
+// checkOCSPResponse returns the status in a DER encoded OCSP response.
+func checkOCSPResponse(bs []byte, cert, issuer *x509.Certificate, source, responderURL string) (tlsRevocationStatus, error) {
+	res, err := ocsp.ParseResponseForCert(bs, cert, issuer)
+	if err != nil {
+		return tlsRevocationStatus{}, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	if !res.NextUpdate.IsZero() && time.Now().After(res.NextUpdate) {
+		return tlsRevocationStatus{}, fmt.Errorf("OCSP response is stale (next update was %s)", res.NextUpdate)
+	}
+
+	status := tlsRevocationStatus{Source: source, URL: responderURL}
+	switch res.Status {
+	case ocsp.Good:
+		status.Status = revocationStatusGood
+	case ocsp.Revoked:
+		status.Status = revocationStatusRevoked
+		status.RevokedAt = &res.RevokedAt
+		status.Reason = getRevocationReason(res.RevocationReason)
+	default:
+		return tlsRevocationStatus{}, fmt.Errorf("OCSP responder doesn't know the certificate")
+	}
+	return status, nil
+}
+
+// checkCRL returns the status of a certificate in a CRL signed by its issuer.
+func checkCRL(crl *x509.RevocationList, cert, issuer *x509.Certificate, source, crlURL string) (tlsRevocationStatus, error) {
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return tlsRevocationStatus{}, fmt.Errorf("invalid CRL signature: %w", err)
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		return tlsRevocationStatus{}, fmt.Errorf("CRL is stale (next update was %s)", crl.NextUpdate)
+	}
+
+	status := tlsRevocationStatus{Status: revocationStatusGood, Source: source, URL: crlURL}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			status.Status = revocationStatusRevoked
+			revokedAt := entry.RevocationTime
+			status.RevokedAt = &revokedAt
+			status.Reason = getRevocationReason(entry.ReasonCode)
+			break
+		}
+	}
+	return status, nil
+}
+
+// parseCRL parses a PEM or DER encoded certificate revocation list.
+func parseCRL(bs []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(bs); block != nil {
+		bs = block.Bytes
+	}
+	return x509.ParseRevocationList(bs)
+}
+
+// getRevocationReason returns the name of an RFC 5280 revocation reason code.
+func getRevocationReason(code int) string {
+	switch code {
+	case ocsp.Unspecified:
+		return "unspecified"
+	case ocsp.KeyCompromise:
+		return "keyCompromise"
+	case ocsp.CACompromise:
+		return "cACompromise"
+	case ocsp.AffiliationChanged:
+		return "affiliationChanged"
+	case ocsp.Superseded:
+		return "superseded"
+	case ocsp.CessationOfOperation:
+		return "cessationOfOperation"
+	case ocsp.CertificateHold:
+		return "certificateHold"
+	case ocsp.RemoveFromCRL:
+		return "removeFromCRL"
+	case ocsp.PrivilegeWithdrawn:
+		return "privilegeWithdrawn"
+	case ocsp.AACompromise:
+		return "aACompromise"
+	}
+	return fmt.Sprintf("unknown (%d)", code)
+}
@@ -0,0 +1,317 @@
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerialNumber atomic.Int64
+
+// newTestCertificate issues a certificate from the template, signed by parent
+// or self-signed if parent is nil.
+func newTestCertificate(t *testing.T, parent *testCA, template *x509.Certificate) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(testSerialNumber.Add(1))
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+	if template.IsCA {
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		if template.ExtKeyUsage == nil {
+			template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		}
+	}
+
+	issuer, issuerKey := template, key
+	if parent != nil {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// newTestCRL returns a PEM encoded CRL signed by the CA revoking the given
+// certificates.
+func newTestCRL(t *testing.T, ca *testCA, revoked ...*x509.Certificate) []byte {
+	t.Helper()
+
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, cert := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: time.Now().Add(-time.Minute),
+			ReasonCode:     ocsp.KeyCompromise,
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+// newTestOCSPResponse returns an OCSP response for the certificate signed by
+// its issuer.
+func newTestOCSPResponse(t *testing.T, issuer *testCA, cert *x509.Certificate, status int) []byte {
+	t.Helper()
+
+	bs, err := ocsp.CreateResponse(issuer.cert, issuer.cert, ocsp.Response{
+		Status:           status,
+		SerialNumber:     cert.SerialNumber,
+		ThisUpdate:       time.Now().Add(-time.Minute),
+		NextUpdate:       time.Now().Add(time.Hour),
+		RevokedAt:        time.Now().Add(-time.Minute),
+		RevocationReason: ocsp.KeyCompromise,
+	}, issuer.key)
+	require.NoError(t, err)
+	return bs
+}
+
+func TestRevocation(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	rawJWT := signer.Sign(t, jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+
+	root := newTestCertificate(t, nil, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Root CA"},
+		IsCA:    true,
+	})
+	intermediate := newTestCertificate(t, root, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Intermediate CA"},
+		IsCA:    true,
+	})
+	rootPath := filepath.Join(t.TempDir(), "root.pem")
+	require.NoError(t, os.WriteFile(rootPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: root.cert.Raw,
+	}), 0o600))
+
+	// the OCSP responder answers with the status for each serial number
+	ocspStatuses := map[string]int{}
+	ocspSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(bs)
+		if !assert.NoError(t, err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		leaf := &x509.Certificate{SerialNumber: req.SerialNumber}
+		_, _ = w.Write(newTestOCSPResponse(t, intermediate, leaf, ocspStatuses[req.SerialNumber.String()]))
+	}))
+	t.Cleanup(ocspSrv.Close)
+
+	crlSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(crlSrv.Close)
+
+	// newJWKSServer starts a JWKS server with a new leaf certificate issued by
+	// the intermediate
+	newJWKSServer := func(t *testing.T, template *x509.Certificate, staple func(leaf *x509.Certificate) []byte) (*httptest.Server, *x509.Certificate) {
+		t.Helper()
+
+		template.Subject = pkix.Name{CommonName: "127.0.0.1"}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		leaf := newTestCertificate(t, intermediate, template)
+		cert := tls.Certificate{
+			Certificate: [][]byte{leaf.cert.Raw, intermediate.cert.Raw},
+			PrivateKey:  leaf.key,
+		}
+		if staple != nil {
+			cert.OCSPStaple = staple(leaf.cert)
+		}
+
+		srv := httptest.NewUnstartedServer(newTestJWKSHandler(signer.JWK()))
+		srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+		return srv, leaf.cert
+	}
+	verify := func(t *testing.T, jwksSrv *httptest.Server, options ...Option) (*tlsReport, error) {
+		t.Helper()
+
+		srv := newTestServer(t, append([]Option{
+			WithJWKSEndpoint(jwksSrv.URL),
+			WithExtraCACerts(rootPath),
+			WithRevocationCheck(true),
+		}, options...)...)
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		report := srv.defaultVerifier.tlsVerifier.GetTLSReport("127.0.0.1")
+		require.NotNil(t, report)
+		require.Len(t, report.Revocation, 2)
+		return report, err
+	}
+
+	t.Run("ocsp good", func(t *testing.T) {
+		jwksSrv, _ := newJWKSServer(t, &x509.Certificate{OCSPServer: []string{ocspSrv.URL}}, nil)
+
+		report, err := verify(t, jwksSrv, WithStrictTLS(true))
+		require.NoError(t, err)
+		assert.True(t, report.Verified)
+		assert.Equal(t, revocationStatusGood, report.Revocation[0].Status)
+		assert.Equal(t, revocationSourceOCSP, report.Revocation[0].Source)
+		assert.Equal(t, ocspSrv.URL, report.Revocation[0].URL)
+		assert.Equal(t, "CN=Test Intermediate CA", report.Revocation[1].Subject)
+		assert.Equal(t, revocationStatusUnknown, report.Revocation[1].Status)
+	})
+	t.Run("ocsp revoked", func(t *testing.T) {
+		jwksSrv, leaf := newJWKSServer(t, &x509.Certificate{OCSPServer: []string{ocspSrv.URL}}, nil)
+		ocspStatuses[leaf.SerialNumber.String()] = ocsp.Revoked
+
+		report, err := verify(t, jwksSrv, WithStrictTLS(true))
+		require.Error(t, err)
+		assert.ErrorIs(t, err, errCertificateRevoked)
+		assert.True(t, isTLSError(err))
+		assert.False(t, report.Verified)
+		assert.Equal(t, revocationStatusRevoked, report.Revocation[0].Status)
+		assert.Equal(t, "keyCompromise", report.Revocation[0].Reason)
+	})
+	t.Run("ocsp stapled", func(t *testing.T) {
+		jwksSrv, _ := newJWKSServer(t, &x509.Certificate{}, func(leaf *x509.Certificate) []byte {
+			return newTestOCSPResponse(t, intermediate, leaf, ocsp.Good)
+		})
+
+		report, err := verify(t, jwksSrv)
+		require.NoError(t, err)
+		assert.Equal(t, revocationStatusGood, report.Revocation[0].Status)
+		assert.Equal(t, revocationSourceOCSPStapled, report.Revocation[0].Source)
+	})
+	t.Run("crl file", func(t *testing.T) {
+		jwksSrv, _ := newJWKSServer(t, &x509.Certificate{}, nil)
+		crlPath := filepath.Join(t.TempDir(), "root.crl")
+		require.NoError(t, os.WriteFile(crlPath, newTestCRL(t, root, intermediate.cert), 0o600))
+
+		report, err := verify(t, jwksSrv, WithCRLFile(crlPath))
+		assert.NoError(t, err, "revoked certificates are only reported in lenient mode")
+		assert.False(t, report.Verified)
+		assert.Contains(t, report.Error, "revoked")
+		assert.Equal(t, revocationStatusRevoked, report.Revocation[1].Status)
+		assert.Equal(t, revocationSourceCRLFile, report.Revocation[1].Source)
+		assert.Equal(t, crlPath, report.Revocation[1].URL)
+	})
+	t.Run("crl file reload", func(t *testing.T) {
+		jwksSrv, _ := newJWKSServer(t, &x509.Certificate{}, nil)
+		crlPath := filepath.Join(t.TempDir(), "root.crl")
+		require.NoError(t, os.WriteFile(crlPath, newTestCRL(t, root), 0o600))
+
+		rc, err := newRevocationChecker([]string{crlPath})
+		require.NoError(t, err)
+		changed, err := rc.loadCRLFiles()
+		require.NoError(t, err)
+		assert.False(t, changed, "unchanged files should not be reloaded")
+
+		require.NoError(t, os.WriteFile(crlPath, newTestCRL(t, root, intermediate.cert), 0o600))
+		changed, err = rc.loadCRLFiles()
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		require.NoError(t, os.WriteFile(crlPath, []byte("not a CRL"), 0o600))
+		changed, err = rc.loadCRLFiles()
+		assert.Error(t, err)
+		assert.False(t, changed, "invalid files should keep the previous CRL")
+
+		srv := newTestServer(t,
+			WithJWKSEndpoint(jwksSrv.URL),
+			WithExtraCACerts(rootPath),
+			WithRevocationCheck(true))
+		srv.defaultVerifier.tlsVerifier.revocation = rc
+		_, err = verifyTestJWT(t, srv, rawJWT)
+		require.NoError(t, err)
+		report := srv.defaultVerifier.tlsVerifier.GetTLSReport("127.0.0.1")
+		require.NotNil(t, report)
+		require.Len(t, report.Revocation, 2)
+		assert.Equal(t, revocationStatusRevoked, report.Revocation[1].Status)
+		assert.Equal(t, revocationSourceCRLFile, report.Revocation[1].Source)
+	})
+	t.Run("crl distribution point", func(t *testing.T) {
+		crl := newTestCRL(t, intermediate)
+		var crlFetches atomic.Int32
+		crlSrv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crlFetches.Add(1)
+			_, _ = w.Write(crl)
+		})
+		jwksSrv, leaf := newJWKSServer(t, &x509.Certificate{CRLDistributionPoints: []string{crlSrv.URL}}, nil)
+
+		report, err := verify(t, jwksSrv, WithStrictTLS(true))
+		require.NoError(t, err)
+		assert.Equal(t, revocationStatusGood, report.Revocation[0].Status)
+		assert.Equal(t, revocationSourceCRL, report.Revocation[0].Source)
+
+		crl = newTestCRL(t, intermediate, leaf)
+		report, err = verify(t, jwksSrv, WithStrictTLS(true))
+		require.Error(t, err)
+		assert.Equal(t, revocationStatusRevoked, report.Revocation[0].Status)
+		assert.Equal(t, int32(2), crlFetches.Load())
+	})
+	t.Run("slow responders", func(t *testing.T) {
+		done := make(chan struct{})
+		slowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			}
+		}))
+		t.Cleanup(slowSrv.Close)
+		t.Cleanup(func() { close(done) })
+		jwksSrv, _ := newJWKSServer(t, &x509.Certificate{
+			OCSPServer:            []string{slowSrv.URL + "/ocsp1", slowSrv.URL + "/ocsp2"},
+			CRLDistributionPoints: []string{slowSrv.URL + "/crl"},
+		}, nil)
+
+		srv := newTestServer(t,
+			WithJWKSEndpoint(jwksSrv.URL),
+			WithExtraCACerts(rootPath),
+			WithRevocationCheck(true),
+		)
+		srv.defaultVerifier.tlsVerifier.revocation.timeout = 100 * time.Millisecond
+
+		// the checks give up together, well before the handshake times out
+		start := time.Now()
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		assert.NoError(t, err)
+		assert.Less(t, time.Since(start), maxRemoteWait)
+
+		report := srv.defaultVerifier.tlsVerifier.GetTLSReport("127.0.0.1")
+		require.NotNil(t, report)
+		require.Len(t, report.Revocation, 2)
+		assert.Equal(t, revocationStatusUnknown, report.Revocation[0].Status)
+	})
+}
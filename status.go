@@ -0,0 +1,78 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// status values
+const (
+	statusOK      = "ok"
+	statusWarning = "warning"
+)
+
+// where a certificate in the status report was seen
+const (
+	certificateSourceJWKS    = "jwks"
+	certificateSourceServing = "serving"
+)
+
+// A certificateStatus is the expiry status of a certificate chain.
+type certificateStatus struct {
+	Source     string     `json:"source"`
+	ServerName string     `json:"serverName"`
+	NotAfter   *time.Time `json:"notAfter,omitempty"`
+	ExpiresIn  string     `json:"expiresIn,omitempty"`
+	Warning    bool       `json:"warning"`
+}
+
+// A statusReport summarizes problems that don't yet prevent verify from
+// working, for monitoring.
+type statusReport struct {
+	Status       string              `json:"status"`
+	Certificates []certificateStatus `json:"certificates"`
+}
+
+// getStatus returns the expiry status of the serving certificate, and of every
+// certificate chain seen when fetching keys.
+func (srv *Server) getStatus() *statusReport {
+	report := &statusReport{
+		Status:       statusOK,
+		Certificates: []certificateStatus{},
+	}
+	if cs := srv.getServingCertificateStatus(time.Now()); cs != nil {
+		report.Certificates = append(report.Certificates, *cs)
+		if cs.Warning {
+			report.Status = statusWarning
+		}
+	}
+
+	seen := map[*tlsVerifier]bool{}
+	for _, iv := range srv.getAllIssuerVerifiers() {
+		// trusted issuers without their own CA certs share the default verifier
+		if seen[iv.tlsVerifier] {
+			continue
+		}
+		seen[iv.tlsVerifier] = true
+
+		for _, tr := range iv.tlsVerifier.GetTLSReports() {
+			report.Certificates = append(report.Certificates, certificateStatus{
+				Source:     certificateSourceJWKS,
+				ServerName: tr.ServerName,
+				NotAfter:   tr.NotAfter,
+				ExpiresIn:  tr.ExpiresIn,
+				Warning:    tr.ExpiryWarning,
+			})
+			if tr.ExpiryWarning {
+				report.Status = statusWarning
+			}
+		}
+	}
+	return report
+}
+
+func (srv *Server) serveAPIStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(srv.getStatus())
+}
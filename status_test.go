@@ -0,0 +1,72 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertExpiryStatus(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	jwksSrv := httptest.NewTLSServer(newTestJWKSHandler(signer.JWK()))
+	t.Cleanup(jwksSrv.Close)
+	notAfter := jwksSrv.Certificate().NotAfter
+
+	rawJWT := signer.Sign(t, jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	getStatus := func(t *testing.T, srv *Server) statusReport {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res statusReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res
+	}
+
+	t.Run("no connections", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL))
+		assert.Equal(t, statusReport{Status: statusOK, Certificates: []certificateStatus{}}, getStatus(t, srv))
+	})
+	t.Run("ok", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL))
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		require.NoError(t, err)
+
+		res := getStatus(t, srv)
+		assert.Equal(t, statusOK, res.Status)
+		require.Len(t, res.Certificates, 1)
+		assert.Equal(t, certificateSourceJWKS, res.Certificates[0].Source)
+		assert.Equal(t, "127.0.0.1", res.Certificates[0].ServerName)
+		require.NotNil(t, res.Certificates[0].NotAfter)
+		assert.True(t, notAfter.Equal(*res.Certificates[0].NotAfter))
+		assert.NotEmpty(t, res.Certificates[0].ExpiresIn)
+		assert.False(t, res.Certificates[0].Warning)
+	})
+	t.Run("warning", func(t *testing.T) {
+		threshold := time.Until(notAfter) + time.Hour
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL), WithCertExpiryWarning(threshold))
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		require.NoError(t, err)
+
+		res := getStatus(t, srv)
+		assert.Equal(t, statusWarning, res.Status)
+		require.Len(t, res.Certificates, 1)
+		assert.True(t, res.Certificates[0].Warning)
+
+		report := srv.defaultVerifier.tlsVerifier.GetTLSReport("127.0.0.1")
+		require.NotNil(t, report)
+		assert.True(t, report.ExpiryWarning)
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,19 +17,33 @@ import (
 const maxRemoteWait = 5 * time.Second
 
 type tlsVerifierOptions struct {
-	rootCAs *x509.CertPool
+	// strict fails the handshake if the certificate is invalid, instead of
+	// only recording the error
+	strict bool
+	// expiryWarning is how long before the earliest expiry in a chain a
+	// warning is reported
+	expiryWarning time.Duration
+	// revocation is set when revocation checking is enabled
+	revocation *revocationChecker
+	// spkiPins maps lowercase server names to their pinned public key hashes
+	spkiPins map[string][]string
+	// caCerts replaces the system roots when set
+	caCerts *caCertPool
+	// clientCert is presented when a server asks for a client certificate
+	clientCert *keyPair
 }
 
 type tlsVerifier struct {
 	tlsVerifierOptions
-	mu     sync.Mutex
-	errors map[string]error
+	mu      sync.Mutex
+	reports map[string]*tlsReport
+	history tlsHistory
 }
 
 func newTLSVerifier(opts tlsVerifierOptions) *tlsVerifier {
 	return &tlsVerifier{
 		tlsVerifierOptions: opts,
-		errors:             make(map[string]error),
+		reports:            make(map[string]*tlsReport),
 	}
 }
 
@@ -36,60 +52,234 @@ func (v *tlsVerifier) DialTLSContext(ctx context.Context, network, addr string)
 		Timeout:   maxRemoteWait,
 		KeepAlive: maxRemoteWait,
 	}
+	serverName := tlsHost(addr)
+	start := time.Now()
 	log.Info().Str("addr", addr).Msg("dialing")
-	conn, err := tls.DialWithDialer(dialer, network, addr, &tls.Config{
+	rawConn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		v.recordHandshake(serverName, tlsHandshake{Time: start, Error: err.Error()})
+		log.Error().Err(err).Str("addr", addr).Msg("error dialing TLS")
+		return nil, err
+	}
+
+	// the certificates, including their revocation status, are verified
+	// within the handshake timeout
+	handshakeCtx, cancel := context.WithTimeout(ctx, maxRemoteWait)
+	var report *tlsReport
+	clientCertReport := new(tlsClientCertReport)
+	conn := tls.Client(rawConn, &tls.Config{
 		InsecureSkipVerify: true,
-		ServerName:         tlsHost(addr),
-		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			return v.VerifyPeerCertificate(tlsHost(addr), rawCerts)
+		ServerName:         serverName,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			var err error
+			report, err = v.VerifyConnection(handshakeCtx, serverName, cs.PeerCertificates, cs.OCSPResponse)
+			return err
 		},
+		GetClientCertificate: v.clientCert.getClientCertificate(clientCertReport),
 	})
+	err = conn.HandshakeContext(handshakeCtx)
+	cancel()
+
+	handshake := tlsHandshake{
+		Time:       start,
+		RemoteAddr: rawConn.RemoteAddr().String(),
+		Success:    err == nil,
+	}
+	if report != nil && len(report.Certificates) > 0 {
+		handshake.LeafFingerprint = report.Certificates[0].SHA256Fingerprint
+	}
+	switch {
+	case err != nil:
+		handshake.Error = err.Error()
+	case report != nil && report.err != nil:
+		// the certificate error was only reported, but it is still a failure
+		handshake.Success = false
+		handshake.Error = report.Error
+	}
+	v.recordHandshake(serverName, handshake)
+
 	if err != nil {
+		_ = rawConn.Close()
+		if isTLSAlert(err) {
+			clientCertReport.setAccepted(err)
+		}
+		v.setClientCertReport(serverName, *clientCertReport)
 		log.Error().Err(err).Str("addr", addr).Msg("error dialing TLS")
 		return nil, err
 	}
-	return conn, nil
+	if !clientCertReport.Sent || conn.ConnectionState().Version < tls.VersionTLS13 {
+		// before TLS 1.3 the server has accepted the certificate by the end of
+		// the handshake
+		clientCertReport.setAccepted(nil)
+		v.setClientCertReport(serverName, *clientCertReport)
+		return conn, nil
+	}
+
+	// with TLS 1.3 the server verifies the certificate after the handshake, so
+	// wait for the first read to see whether it was rejected
+	v.setClientCertReport(serverName, *clientCertReport)
+	return &clientCertConn{
+		Conn: conn,
+		done: func(err error) {
+			clientCertReport.setAccepted(err)
+			v.setClientCertReport(serverName, *clientCertReport)
+			if err != nil {
+				handshake.Time = time.Now()
+				handshake.Success = false
+				handshake.Error = err.Error()
+				v.recordHandshake(serverName, handshake)
+			}
+		},
+	}, nil
+}
+
+// recordHandshake adds the handshake to the server's history.
+func (v *tlsVerifier) recordHandshake(serverName string, handshake tlsHandshake) {
+	if failures := v.history.record(serverName, handshake); failures > 1 {
+		log.Warn().
+			Str("server-name", serverName).
+			Str("remote-addr", handshake.RemoteAddr).
+			Int("consecutive-failures", failures).
+			Msg("repeated TLS failures")
+	}
 }
 
-func (v *tlsVerifier) VerifyPeerCertificate(serverName string, rawCerts [][]byte) error {
-	certs := make([]*x509.Certificate, len(rawCerts))
-	for i, rawCert := range rawCerts {
-		var err error
-		certs[i], err = x509.ParseCertificate(rawCert)
-		if err != nil {
-			log.Error().Err(err).Msg("error parsing TLS certificate")
-			return err
-		}
+// setClientCertReport records what happened to the client certificate on the
+// last connection to the server. The report is replaced rather than modified,
+// since copies returned by GetTLSReport may still be in use.
+func (v *tlsVerifier) setClientCertReport(serverName string, clientCertReport tlsClientCertReport) {
+	if v.clientCert == nil && !clientCertReport.Requested {
+		return
 	}
 
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	report := v.reports[serverName]
+	if report == nil {
+		return
+	}
+	cp := *report
+	cp.ClientCertificate = &clientCertReport
+	v.reports[serverName] = &cp
+}
+
+// VerifyConnection verifies the certificates presented by the server, and
+// records and returns the report. The OCSP response is the one stapled by the
+// server, if any. It only returns an error in strict mode, or if the server's
+// pinned public keys don't match.
+func (v *tlsVerifier) VerifyConnection(ctx context.Context, serverName string, certs []*x509.Certificate, ocspResponse []byte) (*tlsReport, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("tls: server presented no certificates")
+	}
+
+	var roots *x509.CertPool
+	var caCertFiles map[string]string
+	if v.caCerts != nil {
+		roots, caCertFiles = v.caCerts.get()
+	}
 	opts := x509.VerifyOptions{
 		DNSName:       serverName,
-		Roots:         v.rootCAs,
+		Roots:         roots,
 		Intermediates: x509.NewCertPool(),
 	}
 	for _, cert := range certs[1:] {
 		opts.Intermediates.AddCert(cert)
 	}
-	_, err := certs[0].Verify(opts)
-	v.mu.Lock()
+	chains, err := certs[0].Verify(opts)
+	now := time.Now()
+	report := newTLSReport(serverName, certs, now)
+	// only the leaf is pinned if the chain couldn't be verified, since the
+	// other certificates presented by the server prove nothing
+	pinChain := certs[:1]
 	if err == nil {
-		delete(v.errors, serverName)
-	} else {
-		v.errors[serverName] = err
+		report.setVerifiedChain(chains[0], caCertFiles)
+		pinChain = chains[0]
+	}
+	var pinErr error
+	if pins := v.spkiPins[strings.ToLower(serverName)]; len(pins) > 0 {
+		report.Pin, pinErr = checkSPKIPins(pins, pinChain)
+	}
+	if err == nil && v.revocation != nil {
+		err = report.setRevocation(v.revocation.check(ctx, chains[0], ocspResponse))
+	}
+	if err != nil {
+		report.setError(err)
 		log.Error().
 			Err(err).
 			Str("server-name", serverName).
 			Msg("invalid TLS certificate")
 	}
+	if pinErr != nil {
+		if err == nil {
+			report.setError(pinErr)
+		}
+		log.Error().
+			Err(pinErr).
+			Str("server-name", serverName).
+			Strs("pins", report.Pin.Pins).
+			Msg("TLS certificate doesn't match pinned public keys")
+	}
+	if report.checkExpiry(now, v.expiryWarning); report.ExpiryWarning {
+		log.Warn().
+			Str("server-name", serverName).
+			Time("not-after", *report.NotAfter).
+			Str("expires-in", report.ExpiresIn).
+			Msg("TLS certificate is expiring soon")
+	}
+	v.mu.Lock()
+	v.reports[serverName] = report
 	v.mu.Unlock()
-	return nil
+	if pinErr != nil {
+		// pins are enforced even when not strict
+		return report, pinErr
+	}
+	if v.strict {
+		return report, err
+	}
+	return report, nil
 }
 
 func (v *tlsVerifier) GetTLSError(serverName string) error {
+	report := v.GetTLSReport(serverName)
+	if report == nil {
+		return nil
+	}
+	return report.err
+}
+
+// GetTLSReport returns the report for the last TLS connection to the server,
+// or nil if there hasn't been one. The time until expiry is as of now.
+func (v *tlsVerifier) GetTLSReport(serverName string) *tlsReport {
+	v.mu.Lock()
+	report := v.reports[serverName]
+	v.mu.Unlock()
+	if report == nil {
+		return nil
+	}
+
+	cp := *report
+	cp.checkExpiry(time.Now(), v.expiryWarning)
+	return &cp
+}
+
+// GetTLSReports returns the reports for every server, sorted by server name.
+func (v *tlsVerifier) GetTLSReports() []*tlsReport {
 	v.mu.Lock()
-	err := v.errors[serverName]
+	serverNames := make([]string, 0, len(v.reports))
+	for serverName := range v.reports {
+		serverNames = append(serverNames, serverName)
+	}
 	v.mu.Unlock()
-	return err
+	sort.Strings(serverNames)
+
+	reports := make([]*tlsReport, 0, len(serverNames))
+	for _, serverName := range serverNames {
+		if report := v.GetTLSReport(serverName); report != nil {
+			reports = append(reports, report)
+		}
+	}
+	return reports
 }
 
 func tlsHost(targetAddr string) string {
@@ -98,3 +288,27 @@ func tlsHost(targetAddr string) string {
 	}
 	return targetAddr
 }
+
+// isTLSError returns true if the error was caused by a failed TLS handshake or
+// certificate verification.
+func isTLSError(err error) bool {
+	var (
+		verificationErr  *tls.CertificateVerificationError
+		recordHeaderErr  tls.RecordHeaderError
+		unknownAuthority x509.UnknownAuthorityError
+		hostnameErr      x509.HostnameError
+		invalidErr       x509.CertificateInvalidError
+		opErr            *net.OpError
+	)
+	if errors.As(err, &opErr) && (opErr.Op == "remote error" || opErr.Op == "local error") {
+		// TLS alerts are reported as net.OpErrors by crypto/tls
+		return true
+	}
+	return errors.Is(err, errCertificateRevoked) ||
+		errors.Is(err, errSPKIPinMismatch) ||
+		errors.As(err, &verificationErr) ||
+		errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
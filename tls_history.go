@@ -0,0 +1,141 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tlsHistorySize is how many handshakes are kept for each server.
+	tlsHistorySize = 20
+	// tlsHistoryRetention is how long a server is remembered after its last
+	// handshake.
+	tlsHistoryRetention = 24 * time.Hour
+)
+
+// A tlsHandshake is the outcome of a TLS connection to a server.
+type tlsHandshake struct {
+	Time time.Time `json:"time"`
+	// RemoteAddr is the address connected to, which tells load balanced
+	// replicas apart.
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	// LeafFingerprint is the SHA-256 fingerprint of the certificate presented.
+	LeafFingerprint string `json:"leafFingerprint,omitempty"`
+}
+
+// A tlsServerHistory summarizes the recent TLS connections to a server. A
+// handshake fails if the connection couldn't be made or the certificate
+// couldn't be verified, even when the error is only reported.
+type tlsServerHistory struct {
+	// Issuer is the trusted issuer whose CA certs were used, if any.
+	Issuer              string     `json:"issuer,omitempty"`
+	ServerName          string     `json:"serverName"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure         *time.Time `json:"lastFailure,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	// Handshakes are the most recent handshakes, newest first.
+	Handshakes []tlsHandshake `json:"handshakes"`
+}
+
+// A tlsHistory records the recent TLS connections to each server.
+type tlsHistory struct {
+	mu      sync.Mutex
+	servers map[string]*tlsServerHistory
+}
+
+// record adds a handshake to the server's history, returning the number of
+// consecutive failures.
+func (h *tlsHistory) record(serverName string, handshake tlsHandshake) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.servers == nil {
+		h.servers = make(map[string]*tlsServerHistory)
+	}
+	h.prune(handshake.Time)
+
+	sh := h.servers[serverName]
+	if sh == nil {
+		sh = &tlsServerHistory{ServerName: serverName}
+		h.servers[serverName] = sh
+	}
+	if handshake.Success {
+		sh.LastSuccess = &handshake.Time
+		sh.ConsecutiveFailures = 0
+	} else {
+		sh.LastFailure = &handshake.Time
+		sh.LastError = handshake.Error
+		sh.ConsecutiveFailures++
+	}
+	sh.Handshakes = append([]tlsHandshake{handshake}, sh.Handshakes...)
+	if len(sh.Handshakes) > tlsHistorySize {
+		sh.Handshakes = sh.Handshakes[:tlsHistorySize]
+	}
+	return sh.ConsecutiveFailures
+}
+
+// prune forgets servers with no handshakes since the retention period.
+func (h *tlsHistory) prune(now time.Time) {
+	for serverName, sh := range h.servers {
+		if now.Sub(sh.Handshakes[0].Time) > tlsHistoryRetention {
+			delete(h.servers, serverName)
+		}
+	}
+}
+
+// get returns the history of every server, sorted by server name.
+func (h *tlsHistory) get() []tlsServerHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.prune(time.Now())
+	servers := make([]tlsServerHistory, 0, len(h.servers))
+	for _, sh := range h.servers {
+		cp := *sh
+		cp.Handshakes = append([]tlsHandshake(nil), sh.Handshakes...)
+		servers = append(servers, cp)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].ServerName < servers[j].ServerName
+	})
+	return servers
+}
+
+// getTLSHistory returns the TLS history of every server keys were fetched from,
+// optionally limited to a single server name.
+func (srv *Server) getTLSHistory(serverName string) []tlsServerHistory {
+	servers := []tlsServerHistory{}
+	seen := map[*tlsVerifier]bool{}
+	for _, iv := range srv.getAllIssuerVerifiers() {
+		// trusted issuers without their own CA certs share the default verifier
+		if seen[iv.tlsVerifier] {
+			continue
+		}
+		seen[iv.tlsVerifier] = true
+
+		for _, sh := range iv.tlsVerifier.history.get() {
+			if serverName != "" && !strings.EqualFold(sh.ServerName, serverName) {
+				continue
+			}
+			if iv.tlsVerifier != srv.defaultVerifier.tlsVerifier {
+				sh.Issuer = iv.issuer
+			}
+			servers = append(servers, sh)
+		}
+	}
+	return servers
+}
+
+func (srv *Server) serveAPITLSHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Servers []tlsServerHistory `json:"servers"`
+	}{srv.getTLSHistory(r.FormValue("server"))})
+}
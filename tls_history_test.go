@@ -0,0 +1,117 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSHistory(t *testing.T) {
+	var h tlsHistory
+	now := time.Now()
+
+	assert.Equal(t, 1, h.record("a.example.com", tlsHandshake{Time: now, Error: "first"}))
+	assert.Equal(t, 2, h.record("a.example.com", tlsHandshake{Time: now.Add(time.Second), Error: "second"}))
+	assert.Equal(t, 0, h.record("a.example.com", tlsHandshake{Time: now.Add(2 * time.Second), Success: true}))
+	assert.Equal(t, 1, h.record("a.example.com", tlsHandshake{Time: now.Add(3 * time.Second), Error: "third"}))
+
+	servers := h.get()
+	require.Len(t, servers, 1)
+	sh := servers[0]
+	assert.Equal(t, "a.example.com", sh.ServerName)
+	assert.Equal(t, 1, sh.ConsecutiveFailures)
+	assert.Equal(t, "third", sh.LastError)
+	require.NotNil(t, sh.LastSuccess)
+	assert.True(t, now.Add(2*time.Second).Equal(*sh.LastSuccess))
+	require.NotNil(t, sh.LastFailure)
+	assert.True(t, now.Add(3*time.Second).Equal(*sh.LastFailure))
+	require.Len(t, sh.Handshakes, 4)
+	assert.Equal(t, "third", sh.Handshakes[0].Error, "handshakes should be newest first")
+
+	t.Run("bounded", func(t *testing.T) {
+		for i := 0; i < 2*tlsHistorySize; i++ {
+			h.record("b.example.com", tlsHandshake{Time: now, Error: fmt.Sprint(i)})
+		}
+		for _, sh := range h.get() {
+			if sh.ServerName == "b.example.com" {
+				assert.Len(t, sh.Handshakes, tlsHistorySize)
+				assert.Equal(t, 2*tlsHistorySize, sh.ConsecutiveFailures)
+			}
+		}
+	})
+	t.Run("expired", func(t *testing.T) {
+		h.record("c.example.com", tlsHandshake{Time: now.Add(tlsHistoryRetention + time.Minute), Success: true})
+		servers := h.get()
+		require.Len(t, servers, 1, "servers without recent handshakes should be forgotten")
+		assert.Equal(t, "c.example.com", servers[0].ServerName)
+	})
+}
+
+func TestTLSHistoryEndpoint(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(tlsSrv.Close)
+	u, err := url.Parse(tlsSrv.URL)
+	require.NoError(t, err)
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+
+	srv := newTestServer(t, WithExtraCACerts(caPath))
+	dial := func(t *testing.T) {
+		t.Helper()
+
+		conn, err := srv.defaultVerifier.tlsVerifier.DialTLSContext(context.Background(), "tcp", u.Host)
+		require.NoError(t, err, "certificate errors should only be reported")
+		_ = conn.Close()
+	}
+	getHistory := func(t *testing.T, serverName string) []tlsServerHistory {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/api/tls-history?server="+serverName, nil)
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Servers []tlsServerHistory `json:"servers"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Servers
+	}
+
+	assert.Empty(t, getHistory(t, ""))
+
+	dial(t)
+	require.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: tlsSrv.Certificate().Raw,
+	}), 0o600))
+	require.True(t, srv.defaultVerifier.tlsVerifier.caCerts.load())
+	dial(t)
+
+	servers := getHistory(t, "127.0.0.1")
+	require.Len(t, servers, 1)
+	sh := servers[0]
+	assert.Equal(t, 0, sh.ConsecutiveFailures)
+	assert.NotNil(t, sh.LastSuccess)
+	assert.NotNil(t, sh.LastFailure, "the failure should survive the next good handshake")
+	assert.Contains(t, sh.LastError, "unknown authority")
+	require.Len(t, sh.Handshakes, 2)
+	assert.True(t, sh.Handshakes[0].Success)
+	assert.False(t, sh.Handshakes[1].Success)
+	for _, handshake := range sh.Handshakes {
+		assert.Equal(t, u.Host, handshake.RemoteAddr)
+		assert.Equal(t, getCertificateFingerprint(tlsSrv.Certificate()), handshake.LeafFingerprint)
+	}
+
+	assert.Empty(t, getHistory(t, "other.example.com"))
+}
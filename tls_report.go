@@ -0,0 +1,192 @@
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// where the root of a verified certificate chain came from
+const (
+	tlsAnchorSourceSystem = "system"
+	tlsAnchorSourceFile   = "file"
+)
+
+// A tlsReport describes the certificates presented by a server on the last
+// TLS connection to it.
+type tlsReport struct {
+	ServerName string    `json:"serverName"`
+	Time       time.Time `json:"time"`
+	Verified   bool      `json:"verified"`
+	Error      string    `json:"error,omitempty"`
+	err        error
+	// Certificates are the certificates presented by the server, leaf first.
+	Certificates []tlsCertificateInfo `json:"certificates"`
+	// VerifiedChain is the chain from the leaf to a trusted root.
+	VerifiedChain []tlsCertificateInfo `json:"verifiedChain,omitempty"`
+	// AnchorSource is "system" if the root came from the system pool, or
+	// "file" if it came from AnchorFile.
+	AnchorSource string `json:"anchorSource,omitempty"`
+	AnchorFile   string `json:"anchorFile,omitempty"`
+	// MissingIssuer is set when the chain couldn't be built to a trusted
+	// root. It is the issuer of the last certificate presented, which is the
+	// intermediate or root that is missing.
+	MissingIssuer string `json:"missingIssuer,omitempty"`
+	// Pin is set when public keys are pinned for the server.
+	Pin *tlsPinReport `json:"pin,omitempty"`
+	// ClientCertificate is set when the server asked for a client certificate
+	// or one is configured.
+	ClientCertificate *tlsClientCertReport `json:"clientCertificate,omitempty"`
+	// Revocation is the revocation status of each certificate in the verified
+	// chain except the root, when revocation checking is enabled.
+	Revocation []tlsRevocationStatus `json:"revocation,omitempty"`
+	// NotAfter is the earliest expiry in the verified chain, or in the
+	// presented certificates if the chain couldn't be verified.
+	NotAfter      *time.Time `json:"notAfter,omitempty"`
+	ExpiresIn     string     `json:"expiresIn,omitempty"`
+	ExpiryWarning bool       `json:"expiryWarning"`
+}
+
+// A tlsCertificateInfo describes an X.509 certificate.
+type tlsCertificateInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SANs               []string  `json:"sans,omitempty"`
+	SerialNumber       string    `json:"serialNumber"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	KeyAlgorithm       string    `json:"keyAlgorithm"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	SHA256Fingerprint  string    `json:"sha256Fingerprint"`
+	// SPKISHA256 is the base64 encoded SHA-256 hash of the public key, as
+	// used for pinning.
+	SPKISHA256 string `json:"spkiSha256"`
+	IsCA       bool   `json:"isCA"`
+}
+
+func newTLSReport(serverName string, certs []*x509.Certificate, now time.Time) *tlsReport {
+	return &tlsReport{
+		ServerName:   serverName,
+		Time:         now,
+		Certificates: getCertificateInfos(certs),
+	}
+}
+
+// checkExpiry sets the time remaining until the earliest expiry, and whether
+// it is within the warning threshold. A zero threshold disables the warning.
+func (report *tlsReport) checkExpiry(now time.Time, threshold time.Duration) {
+	report.NotAfter = nil
+	chain := report.VerifiedChain
+	if len(chain) == 0 {
+		chain = report.Certificates
+	}
+	for _, cert := range chain {
+		if report.NotAfter == nil || cert.NotAfter.Before(*report.NotAfter) {
+			notAfter := cert.NotAfter
+			report.NotAfter = &notAfter
+		}
+	}
+	if report.NotAfter == nil {
+		return
+	}
+
+	remaining := report.NotAfter.Sub(now)
+	report.ExpiresIn = remaining.Truncate(time.Second).String()
+	report.ExpiryWarning = threshold > 0 && remaining < threshold
+}
+
+func (report *tlsReport) setVerifiedChain(chain []*x509.Certificate, caCertFiles map[string]string) {
+	report.Verified = true
+	report.VerifiedChain = getCertificateInfos(chain)
+	report.AnchorSource = tlsAnchorSourceSystem
+	if path, ok := caCertFiles[getCertificateFingerprint(chain[len(chain)-1])]; ok {
+		report.AnchorSource = tlsAnchorSourceFile
+		report.AnchorFile = path
+	}
+}
+
+// setRevocation records the revocation status of the verified chain,
+// returning an error if any certificate was revoked.
+func (report *tlsReport) setRevocation(statuses []tlsRevocationStatus) error {
+	report.Revocation = statuses
+	for _, status := range statuses {
+		if status.Status == revocationStatusRevoked {
+			return fmt.Errorf("%w: %s (%s from %s)", errCertificateRevoked, status.Subject, status.Reason, status.Source)
+		}
+	}
+	return nil
+}
+
+func (report *tlsReport) setError(err error) {
+	report.Verified = false
+	report.err = err
+	report.Error = err.Error()
+
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) && len(report.Certificates) > 0 {
+		report.MissingIssuer = report.Certificates[len(report.Certificates)-1].Issuer
+	}
+}
+
+func getCertificateInfos(certs []*x509.Certificate) []tlsCertificateInfo {
+	infos := make([]tlsCertificateInfo, len(certs))
+	for i, cert := range certs {
+		infos[i] = getCertificateInfo(cert)
+	}
+	return infos
+}
+
+func getCertificateInfo(cert *x509.Certificate) tlsCertificateInfo {
+	info := tlsCertificateInfo{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       fmt.Sprintf("%X", cert.SerialNumber),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		KeyAlgorithm:       getPublicKeyAlgorithm(cert),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		SHA256Fingerprint:  getCertificateFingerprint(cert),
+		SPKISHA256:         getSPKIHash(cert),
+		IsCA:               cert.IsCA,
+	}
+	for _, name := range cert.DNSNames {
+		info.SANs = append(info.SANs, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		info.SANs = append(info.SANs, "IP:"+ip.String())
+	}
+	for _, u := range cert.URIs {
+		info.SANs = append(info.SANs, "URI:"+u.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		info.SANs = append(info.SANs, "email:"+email)
+	}
+	return info
+}
+
+// getPublicKeyAlgorithm returns the certificate's key algorithm and size, e.g.
+// "ECDSA P-256".
+func getPublicKeyAlgorithm(cert *x509.Certificate) string {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + pub.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// getCertificateFingerprint returns the hex encoded SHA-256 hash of the
+// certificate.
+func getCertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
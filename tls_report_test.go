@@ -0,0 +1,78 @@
+package verify
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSReport(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	jwksSrv := httptest.NewTLSServer(newTestJWKSHandler(signer.JWK()))
+	t.Cleanup(jwksSrv.Close)
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: jwksSrv.Certificate().Raw,
+	}), 0o600))
+
+	getReport := func(t *testing.T, srv *Server) *tlsReport {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/api/verify-info", nil)
+		r.Header.Set(headerJWTAssertion, signer.Sign(t, jwt.Claims{
+			Issuer: "authenticate.example.com",
+			Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}))
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Error string     `json:"error"`
+			TLS   *tlsReport `json:"tls"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Empty(t, res.Error)
+		require.NotNil(t, res.TLS)
+		return res.TLS
+	}
+
+	t.Run("extra ca certs", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL), WithExtraCACerts(caPath))
+
+		report := getReport(t, srv)
+		assert.True(t, report.Verified)
+		assert.Empty(t, report.Error)
+		assert.Equal(t, "127.0.0.1", report.ServerName)
+		require.Len(t, report.Certificates, 1)
+		cert := report.Certificates[0]
+		assert.Equal(t, "O=Acme Co", cert.Subject)
+		assert.Contains(t, cert.SANs, "IP:127.0.0.1")
+		assert.Equal(t, getCertificateFingerprint(jwksSrv.Certificate()), cert.SHA256Fingerprint)
+		assert.NotEmpty(t, cert.KeyAlgorithm)
+		assert.Len(t, report.VerifiedChain, 1)
+		assert.Equal(t, tlsAnchorSourceFile, report.AnchorSource)
+		assert.Equal(t, caPath, report.AnchorFile)
+	})
+	t.Run("unknown authority", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL))
+
+		report := getReport(t, srv)
+		assert.False(t, report.Verified)
+		assert.Contains(t, report.Error, "unknown authority")
+		assert.Len(t, report.Certificates, 1)
+		assert.Empty(t, report.VerifiedChain)
+		assert.Equal(t, "O=Acme Co", report.MissingIssuer)
+	})
+}
@@ -0,0 +1,54 @@
+package verify
+
+import (
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrictTLS(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	jwksSrv := httptest.NewTLSServer(newTestJWKSHandler(signer.JWK()))
+	t.Cleanup(jwksSrv.Close)
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: jwksSrv.Certificate().Raw,
+	}), 0o600))
+
+	rawJWT := signer.Sign(t, jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+
+	t.Run("lenient", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL))
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		assert.NoError(t, err)
+		assert.Error(t, srv.defaultVerifier.tlsVerifier.GetTLSError("127.0.0.1"))
+	})
+	t.Run("strict", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL), WithStrictTLS(true))
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		require.Error(t, err)
+		assert.Equal(t, jwtDiagnosisCodeJWKSTLSFailed, srv.diagnoseJWT(rawJWT, err, time.Now()).Code)
+
+		report := srv.defaultVerifier.tlsVerifier.GetTLSReport("127.0.0.1")
+		require.NotNil(t, report)
+		assert.False(t, report.Verified)
+		assert.Contains(t, report.Error, "unknown authority")
+	})
+	t.Run("strict trusted", func(t *testing.T) {
+		srv := newTestServer(t, WithJWKSEndpoint(jwksSrv.URL), WithStrictTLS(true), WithExtraCACerts(caPath))
+		_, err := verifyTestJWT(t, srv, rawJWT)
+		assert.NoError(t, err)
+	})
+}
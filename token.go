@@ -0,0 +1,213 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+
+	sdk "github.com/pomerium/sdk-go"
+)
+
+// token signature results
+const (
+	TokenSignatureValid      = "valid"
+	TokenSignatureInvalid    = "invalid"
+	TokenSignatureUnverified = "unverified"
+)
+
+// A TokenReport describes a JWT and the result of verifying it.
+type TokenReport struct {
+	Valid     bool            `json:"valid"`
+	Header    map[string]any  `json:"header,omitempty"`
+	Claims    map[string]any  `json:"claims,omitempty"`
+	KeySource string          `json:"keySource,omitempty"`
+	KeyID     string          `json:"keyId,omitempty"`
+	Signature string          `json:"signature"`
+	Time      TokenTimeReport `json:"time"`
+	// Code, Error and Hint explain why verification failed.
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+	Hint  string `json:"hint,omitempty"`
+}
+
+// A TokenTimeReport describes the time-based claims of a JWT relative to the
+// current time.
+type TokenTimeReport struct {
+	Now         time.Time  `json:"now"`
+	IssuedAt    *time.Time `json:"iat,omitempty"`
+	NotBefore   *time.Time `json:"nbf,omitempty"`
+	Expiry      *time.Time `json:"exp,omitempty"`
+	Leeway      string     `json:"leeway"`
+	Expired     bool       `json:"expired"`
+	NotYetValid bool       `json:"notYetValid"`
+	// ExpiresIn is negative if the token has expired.
+	ExpiresIn string `json:"expiresIn,omitempty"`
+	// Skew is the difference between the current time and the time the token
+	// was issued. Pomerium issues a token for each request, so a large skew
+	// suggests the clocks are out of sync.
+	Skew string `json:"skew,omitempty"`
+	// ValidWithLeeway and ValidWithoutLeeway report whether the time-based
+	// claims are valid with and without the leeway.
+	ValidWithLeeway    bool `json:"validWithLeeway"`
+	ValidWithoutLeeway bool `json:"validWithoutLeeway"`
+}
+
+func newTokenTimeReport(claims *jwt.Claims, now time.Time, leeway time.Duration) TokenTimeReport {
+	report := TokenTimeReport{
+		Now:       now,
+		IssuedAt:  getNumericDateTime(claims.IssuedAt),
+		NotBefore: getNumericDateTime(claims.NotBefore),
+		Expiry:    getNumericDateTime(claims.Expiry),
+		Leeway:    leeway.String(),
+	}
+	if report.Expiry != nil {
+		expiresIn := report.Expiry.Sub(now).Round(time.Second)
+		report.ExpiresIn = expiresIn.String()
+		report.Expired = expiresIn < 0
+	}
+	if report.NotBefore != nil {
+		report.NotYetValid = now.Before(*report.NotBefore)
+	}
+	if report.IssuedAt != nil {
+		report.Skew = now.Sub(*report.IssuedAt).Round(time.Second).String()
+	}
+
+	// only the time-based claims are validated when the other claims are unset
+	timeClaims := jwt.Claims{
+		IssuedAt:  claims.IssuedAt,
+		NotBefore: claims.NotBefore,
+		Expiry:    claims.Expiry,
+	}
+	expected := jwt.Expected{Time: now}
+	report.ValidWithLeeway = timeClaims.ValidateWithLeeway(expected, leeway) == nil
+	report.ValidWithoutLeeway = timeClaims.ValidateWithLeeway(expected, 0) == nil
+	return report
+}
+
+// CheckToken verifies a JWT without running the server. It applies the same
+// issuer and audience checks as the server, and returns an error if the
+// options are invalid.
+//
+// keySource is a JWKS URL, a JWKS file or a file containing PEM encoded public
+// keys. If it is empty, the configured JWKS file or data is used, or keys are
+// fetched from the configured JWKS endpoint, the endpoint found with OIDC
+// discovery, or the token issuer's well-known Pomerium endpoint.
+func CheckToken(ctx context.Context, rawJWT, keySource string, options ...Option) (*TokenReport, error) {
+	srv, err := newVerifierServer(getConfig(options...))
+	if err != nil {
+		return nil, err
+	}
+	err = srv.initIssuerVerifiers()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rawJWT = strings.TrimSpace(rawJWT)
+	report := &TokenReport{
+		Signature: TokenSignatureUnverified,
+		Time: TokenTimeReport{
+			Now:    now,
+			Leeway: srv.cfg.jwtLeeway.String(),
+		},
+	}
+	fail := func(err error) (*TokenReport, error) {
+		d := srv.diagnoseJWT(rawJWT, err, now)
+		report.Code = string(d.Code)
+		report.Error = d.Error
+		report.Hint = d.Hint
+		return report, nil
+	}
+
+	if rawJWT == "" {
+		return fail(sdk.ErrTokenNotFound)
+	}
+
+	sig, err := parseCompactJWS(rawJWT)
+	if err != nil {
+		return fail(fmt.Errorf("failed to parse Pomerium JWT assertion: %w", err))
+	}
+	_ = json.Unmarshal(getJWSProtectedHeader(rawJWT), &report.Header)
+	_ = json.Unmarshal(sig.UnsafePayloadWithoutVerification(), &report.Claims)
+	var claims jwt.Claims
+	_ = json.Unmarshal(sig.UnsafePayloadWithoutVerification(), &claims)
+	report.Time = newTokenTimeReport(&claims, now, srv.cfg.jwtLeeway)
+
+	iv, err := srv.getIssuerVerifier(rawJWT)
+	if err != nil {
+		return fail(err)
+	}
+	sig, err = iv.parseJWT(rawJWT)
+	if err != nil {
+		return fail(err)
+	}
+
+	var keys []*jose.JSONWebKey
+	if keySource == "" {
+		keys, err = iv.getKeys(ctx, rawJWT)
+		report.KeySource = iv.getJWKSEndpoint(claims.Issuer)
+	} else {
+		var jwks *jose.JSONWebKeySet
+		jwks, err = loadJWKS(ctx, iv.tlsVerifier, keySource)
+		if err == nil {
+			keys = findJWKs(jwks.Keys, sig.Signatures[0].Protected.KeyID)
+		} else {
+			err = fmt.Errorf("%w from %s: %w", errJWKSFetch, keySource, err)
+		}
+		report.KeySource = keySource
+	}
+	if err != nil {
+		return fail(fmt.Errorf("failed to retrieve signature key for Pomerium JWT assertion: %w", err))
+	}
+
+	expected := iv.expected
+	expected.Time = now
+	_, key, err := iv.verifyJWT(sig, keys, expected)
+	if key != nil {
+		report.Signature = TokenSignatureValid
+		report.KeyID = key.KeyID
+	} else if err != nil && !errors.Is(err, sdk.ErrJWKNotFound) {
+		report.Signature = TokenSignatureInvalid
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	report.Valid = true
+	return report, nil
+}
+
+// loadJWKS loads a JSON Web Key Set from a URL or a file.
+func loadJWKS(ctx context.Context, tlsVerifier *tlsVerifier, source string) (*jose.JSONWebKeySet, error) {
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "https" || u.Scheme == "http") {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialTLSContext = tlsVerifier.DialTLSContext
+		return sdk.FetchJSONWebKeySet(ctx, &http.Client{
+			Transport: transport,
+			Timeout:   maxRemoteWait,
+		}, source)
+	}
+
+	bs, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(bs)
+}
+
+func getNumericDateTime(date *jwt.NumericDate) *time.Time {
+	if date == nil {
+		return nil
+	}
+	t := date.Time()
+	return &t
+}
@@ -0,0 +1,95 @@
+package verify
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// token source types
+const (
+	tokenSourceTypeHeader = "header"
+	tokenSourceTypeBearer = "bearer"
+	tokenSourceTypeCookie = "cookie"
+	tokenSourceTypeQuery  = "query"
+)
+
+// defaultTokenSources are the sources used by sdk.AddIdentityToRequest.
+var defaultTokenSources = []string{
+	tokenSourceTypeHeader + ":" + headerJWTAssertion,
+	tokenSourceTypeQuery + ":jwt",
+}
+
+// A tokenSource is a place in a request to look for the JWT assertion.
+type tokenSource struct {
+	typ  string
+	name string
+}
+
+// parseTokenSource parses a token source of the form "header:<name>",
+// "bearer", "cookie:<name>" or "query:<name>".
+func parseTokenSource(raw string) (tokenSource, error) {
+	typ, name, _ := strings.Cut(strings.TrimSpace(raw), ":")
+	ts := tokenSource{typ: strings.ToLower(typ), name: strings.TrimSpace(name)}
+	switch ts.typ {
+	case tokenSourceTypeBearer:
+		if ts.name != "" {
+			return ts, fmt.Errorf("invalid token source %q: bearer does not take a name", raw)
+		}
+	case tokenSourceTypeHeader, tokenSourceTypeCookie, tokenSourceTypeQuery:
+		if ts.name == "" {
+			return ts, fmt.Errorf("invalid token source %q: %s requires a name", raw, ts.typ)
+		}
+		if ts.typ == tokenSourceTypeHeader {
+			ts.name = http.CanonicalHeaderKey(ts.name)
+		}
+	default:
+		return ts, fmt.Errorf("invalid token source %q: expected header, bearer, cookie or query", raw)
+	}
+	return ts, nil
+}
+
+// String returns the token source in the format accepted by parseTokenSource.
+func (ts tokenSource) String() string {
+	if ts.name == "" {
+		return ts.typ
+	}
+	return ts.typ + ":" + ts.name
+}
+
+// getToken returns the token from the request, or "" if there isn't one.
+func (ts tokenSource) getToken(r *http.Request) string {
+	switch ts.typ {
+	case tokenSourceTypeHeader:
+		return r.Header.Get(ts.name)
+	case tokenSourceTypeBearer:
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	case tokenSourceTypeCookie:
+		if cookie, err := r.Cookie(ts.name); err == nil {
+			return cookie.Value
+		}
+	case tokenSourceTypeQuery:
+		return r.URL.Query().Get(ts.name)
+	}
+	return ""
+}
+
+// findRawJWT returns the JWT assertion from the first configured token source
+// that has one, along with the source.
+func (srv *Server) findRawJWT(r *http.Request) (string, tokenSource, bool) {
+	for _, ts := range srv.tokenSources {
+		if rawJWT := ts.getToken(r); rawJWT != "" {
+			return rawJWT, ts, true
+		}
+	}
+	return "", tokenSource{}, false
+}
+
+// getRawJWT returns the JWT assertion from the request.
+func (srv *Server) getRawJWT(r *http.Request) string {
+	rawJWT, _, _ := srv.findRawJWT(r)
+	return rawJWT
+}
@@ -0,0 +1,89 @@
+package verify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTokenSource(t *testing.T) {
+	for _, tc := range []struct {
+		raw    string
+		expect string
+		err    bool
+	}{
+		{raw: "header:x-forwarded-access-token", expect: "header:X-Forwarded-Access-Token"},
+		{raw: "Bearer", expect: "bearer"},
+		{raw: "cookie:_pomerium_jwt", expect: "cookie:_pomerium_jwt"},
+		{raw: " query:jwt ", expect: "query:jwt"},
+		{raw: "header", err: true},
+		{raw: "bearer:token", err: true},
+		{raw: "form:jwt", err: true},
+	} {
+		ts, err := parseTokenSource(tc.raw)
+		if tc.err {
+			assert.Error(t, err, tc.raw)
+		} else if assert.NoError(t, err, tc.raw) {
+			assert.Equal(t, tc.expect, ts.String())
+		}
+	}
+}
+
+func TestTokenSources(t *testing.T) {
+	signer := newTestSigner(t, "key-1")
+	jwksSrv := newTestJWKSServer(t, signer.JWK())
+	rawJWT := signer.Sign(t, jwt.Claims{
+		Issuer: "authenticate.example.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+
+	srv := newTestServer(t,
+		WithJWKSEndpoint(jwksSrv.URL),
+		WithTokenSource("header:X-Custom-Assertion", "bearer", "cookie:_pomerium_jwt", "query:jwt"),
+	)
+
+	for _, tc := range []struct {
+		name   string
+		setup  func(r *http.Request)
+		expect string
+	}{
+		{"none", func(r *http.Request) {}, ""},
+		{"header", func(r *http.Request) { r.Header.Set("X-Custom-Assertion", rawJWT) }, "header:X-Custom-Assertion"},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+rawJWT) }, "bearer"},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "_pomerium_jwt", Value: rawJWT}) }, "cookie:_pomerium_jwt"},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "jwt=" + rawJWT }, "query:jwt"},
+		{"order", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+rawJWT)
+			r.URL.RawQuery = "jwt=invalid"
+		}, "bearer"},
+		{"default header ignored", func(r *http.Request) { r.Header.Set(headerJWTAssertion, rawJWT) }, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/verify-info", nil)
+			tc.setup(r)
+			w := httptest.NewRecorder()
+			srv.router.ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var res struct {
+				Error   string `json:"error"`
+				Request struct {
+					TokenSource string `json:"tokenSource"`
+				} `json:"request"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, tc.expect, res.Request.TokenSource)
+			if tc.expect == "" {
+				assert.NotEmpty(t, res.Error)
+			} else {
+				assert.Empty(t, res.Error)
+			}
+		})
+	}
+}
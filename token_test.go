@@ -0,0 +1,124 @@
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckToken(t *testing.T) {
+	now := time.Now()
+	signer := newTestSigner(t, "key-1")
+	otherSigner := newTestSigner(t, "key-1")
+	jwksSrv := newTestJWKSServer(t, signer.JWK())
+
+	dir := t.TempDir()
+	jwksPath := filepath.Join(dir, "jwks.json")
+	bs, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{signer.JWK()}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(jwksPath, bs, 0o600))
+
+	pemPath := filepath.Join(dir, "key.pem")
+	der, err := x509.MarshalPKIXPublicKey(signer.key.Public())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	validClaims := jwt.Claims{
+		Issuer:   "authenticate.example.com",
+		Audience: jwt.Audience{"verify.example.com"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		IssuedAt: jwt.NewNumericDate(now),
+	}
+	expiredClaims := validClaims
+	expiredClaims.Expiry = jwt.NewNumericDate(now.Add(-time.Hour))
+
+	t.Run("jwks url", func(t *testing.T) {
+		report, err := CheckToken(context.Background(), signer.Sign(t, validClaims), jwksSrv.URL)
+		require.NoError(t, err)
+		assert.True(t, report.Valid, report.Error)
+		assert.Equal(t, TokenSignatureValid, report.Signature)
+		assert.Equal(t, "key-1", report.KeyID)
+		assert.Equal(t, "ES256", report.Header["alg"])
+		assert.Equal(t, "authenticate.example.com", report.Claims["iss"])
+		assert.False(t, report.Time.Expired)
+	})
+	t.Run("jwks file", func(t *testing.T) {
+		report, err := CheckToken(context.Background(), signer.Sign(t, validClaims), jwksPath,
+			WithExpectedJWTIssuer("authenticate.example.com"),
+			WithExpectedJWTAudience("verify.example.com"))
+		require.NoError(t, err)
+		assert.True(t, report.Valid, report.Error)
+		assert.Equal(t, jwksPath, report.KeySource)
+	})
+	t.Run("pem file", func(t *testing.T) {
+		report, err := CheckToken(context.Background(), signer.Sign(t, validClaims), pemPath)
+		require.NoError(t, err)
+		assert.True(t, report.Valid, report.Error)
+		assert.Empty(t, report.KeyID)
+	})
+	t.Run("missing key file", func(t *testing.T) {
+		report, err := CheckToken(context.Background(), signer.Sign(t, validClaims), filepath.Join(dir, "missing.pem"))
+		require.NoError(t, err)
+		assert.False(t, report.Valid)
+		assert.Equal(t, string(jwtDiagnosisCodeJWKSFetchFailed), report.Code)
+	})
+	t.Run("bad signature", func(t *testing.T) {
+		report, err := CheckToken(context.Background(), otherSigner.Sign(t, validClaims), jwksPath)
+		require.NoError(t, err)
+		assert.False(t, report.Valid)
+		assert.Equal(t, TokenSignatureInvalid, report.Signature)
+		assert.Equal(t, string(jwtDiagnosisCodeBadSignature), report.Code)
+	})
+	t.Run("expired", func(t *testing.T) {
+		report, err := CheckToken(context.Background(), signer.Sign(t, expiredClaims), jwksPath)
+		require.NoError(t, err)
+		assert.False(t, report.Valid)
+		assert.Equal(t, TokenSignatureValid, report.Signature)
+		assert.True(t, report.Time.Expired)
+		assert.Equal(t, string(jwtDiagnosisCodeExpired), report.Code)
+	})
+	t.Run("audience mismatch", func(t *testing.T) {
+		report, err := CheckToken(context.Background(), signer.Sign(t, validClaims), jwksPath,
+			WithExpectedJWTAudience("other.example.com"))
+		require.NoError(t, err)
+		assert.False(t, report.Valid)
+		assert.Equal(t, string(jwtDiagnosisCodeAudienceMismatch), report.Code)
+	})
+	t.Run("malformed", func(t *testing.T) {
+		report, err := CheckToken(context.Background(), "not-a-jwt", jwksPath)
+		require.NoError(t, err)
+		assert.False(t, report.Valid)
+		assert.Equal(t, TokenSignatureUnverified, report.Signature)
+		assert.Equal(t, string(jwtDiagnosisCodeMalformedJWS), report.Code)
+	})
+	t.Run("invalid options", func(t *testing.T) {
+		for _, options := range [][]Option{
+			{WithAllowedSigningAlgorithms("HS256")},
+			{WithExpectedJWTIssuer("authenticate.example.com"), WithTrustedIssuer("authenticate.example.com", jwksSrv.URL)},
+			{WithJWKSEndpoint(jwksSrv.URL), WithTrustedIssuer("authenticate.example.com", jwksSrv.URL)},
+			{WithJWKSFile(jwksPath), WithTrustedIssuer("authenticate.example.com", jwksSrv.URL)},
+			{WithOIDCDiscoveryURL(jwksSrv.URL), WithTrustedIssuer("authenticate.example.com", jwksSrv.URL)},
+			{WithJWKSFile(filepath.Join(dir, "missing.json"))},
+		} {
+			_, err := CheckToken(context.Background(), signer.Sign(t, validClaims), jwksPath, options...)
+			assert.Error(t, err)
+		}
+	})
+	t.Run("serving options", func(t *testing.T) {
+		// only the options used to verify tokens are loaded
+		report, err := CheckToken(context.Background(), signer.Sign(t, validClaims), jwksPath,
+			WithTLSCertificate(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing.key")))
+		require.NoError(t, err)
+		assert.True(t, report.Valid, report.Error)
+	})
+}
@@ -1,4 +1,4 @@
-import { encodeUrl } from "@borderless/base64";
+import { decode } from "@borderless/base64";
 
 export type VerifyInfoRequest = {
   host: string;
@@ -7,6 +7,7 @@ export type VerifyInfoRequest = {
   origin: string;
   url: string;
   tlsError: string;
+  tokenSource: string;
 };
 export type VerifyInfoIdentity = {
   iss?: string;
@@ -23,8 +24,159 @@ export type VerifyInfoIdentity = {
   raw_jwt?: string;
   public_key?: string;
 };
+export type VerifyInfoJWTHeader = {
+  alg?: string;
+  kid?: string;
+  typ?: string;
+  cty?: string;
+  jku?: string;
+  x5u?: string;
+};
+export type VerifyInfoTime = {
+  now: string;
+  iat?: string;
+  nbf?: string;
+  exp?: string;
+  leeway: string;
+  expired: boolean;
+  notYetValid: boolean;
+  expiresIn?: string;
+  skew?: string;
+  validWithLeeway: boolean;
+  validWithoutLeeway: boolean;
+};
+export type VerifyInfoJWKSEndpoint = {
+  url: string;
+  source: "static" | "config" | "oidc_discovery" | "pomerium_default";
+  discoveryUrl?: string;
+  error?: string;
+};
+export type VerifyInfoClaims = {
+  verified: boolean;
+  claims: { [name: string]: unknown };
+  types: { [name: string]: string };
+};
+export type VerifyInfoDiagnosis = {
+  code: string;
+  error: string;
+  hint: string;
+  claim?: string;
+  expected?: unknown;
+  actual?: unknown;
+  serverTime?: number;
+  jwksEndpoint?: string;
+};
+export type VerifyInfoClaimHeaderCheck = {
+  header?: string;
+  claim: string;
+  status: "match" | "mismatch" | "missing_header" | "missing_claim";
+  headerValue?: string;
+  claimValue?: string;
+};
+export type VerifyInfoClaimHeaders = {
+  consistent: boolean;
+  checks: VerifyInfoClaimHeaderCheck[];
+};
+export type VerifyInfoIdentityHop = {
+  source: string;
+  identity?: VerifyInfoIdentity;
+  verified: boolean;
+  error?: string;
+  diagnosis?: VerifyInfoDiagnosis;
+};
+export type VerifyInfoHeaderWarning = {
+  code: string;
+  header: string;
+  message: string;
+  informational?: boolean;
+};
+export type VerifyInfoCertificate = {
+  subject: string;
+  issuer: string;
+  sans?: string[];
+  serialNumber: string;
+  notBefore: string;
+  notAfter: string;
+  keyAlgorithm: string;
+  signatureAlgorithm: string;
+  sha256Fingerprint: string;
+  spkiSha256: string;
+  isCA: boolean;
+};
+export type VerifyInfoRevocationStatus = {
+  subject: string;
+  status: "good" | "revoked" | "unknown";
+  source?: "ocsp_stapled" | "ocsp" | "crl" | "crl_file";
+  url?: string;
+  revokedAt?: string;
+  reason?: string;
+  error?: string;
+};
+export type VerifyInfoClientCertificate = {
+  requested: boolean;
+  sent: boolean;
+  subject?: string;
+  notAfter?: string;
+  accepted?: boolean;
+  error?: string;
+};
+export type VerifyInfoTLS = {
+  serverName: string;
+  time: string;
+  verified: boolean;
+  error?: string;
+  certificates: VerifyInfoCertificate[];
+  verifiedChain?: VerifyInfoCertificate[];
+  anchorSource?: "system" | "file";
+  anchorFile?: string;
+  missingIssuer?: string;
+  pin?: {
+    pins: string[];
+    matched: boolean;
+    matchedPin?: string;
+    matchedSubject?: string;
+  };
+  clientCertificate?: VerifyInfoClientCertificate;
+  revocation?: VerifyInfoRevocationStatus[];
+  notAfter?: string;
+  expiresIn?: string;
+  expiryWarning: boolean;
+};
+export type VerifyInfoCACertFile = {
+  issuer?: string;
+  path: string;
+  loadedAt?: string;
+  error?: string;
+  certificates: {
+    subject: string;
+    sha256Fingerprint: string;
+    notAfter: string;
+  }[];
+};
+export type VerifyInfoClientCertificateAuth = {
+  mode: "request" | "require";
+  presented: boolean;
+  verified: boolean;
+  error?: string;
+  uris?: string[];
+  spiffeId?: string;
+  certificates: VerifyInfoCertificate[];
+  verifiedChain?: VerifyInfoCertificate[];
+  anchorFile?: string;
+};
 export type VerifyInfo = {
   error?: string;
+  diagnosis?: VerifyInfoDiagnosis;
+  claimHeaders?: VerifyInfoClaimHeaders;
+  jwtHeader?: VerifyInfoJWTHeader;
+  time?: VerifyInfoTime;
+  jwksEndpoint?: VerifyInfoJWKSEndpoint;
+  tls?: VerifyInfoTLS;
+  caCerts?: VerifyInfoCACertFile[];
+  clientCertificate?: VerifyInfoClientCertificateAuth;
+  claims?: VerifyInfoClaims;
+  chain?: VerifyInfoIdentityHop[];
+  headerWarnings?: VerifyInfoHeaderWarning[];
   identity?: VerifyInfoIdentity;
   headers: { [name: string]: string[] };
   request: VerifyInfoRequest;
@@ -54,58 +206,74 @@ export type WebAuthnAuthenticateOptions = {
   userVerification?: string;
 };
 
-export function toWebAuthnAuthenticateOptions(
-  options: PublicKeyCredentialRequestOptions,
-): WebAuthnAuthenticateOptions {
-  const obj: WebAuthnAuthenticateOptions = {
-    challenge: encodeUrl(
-      ArrayBuffer.isView(options.challenge) ? options.challenge.buffer : options.challenge,
-    ),
+export function fromWebAuthnAuthenticateOptions(
+  obj: WebAuthnAuthenticateOptions,
+): PublicKeyCredentialRequestOptions {
+  // decode always allocates a fresh, non-shared buffer
+  const options: PublicKeyCredentialRequestOptions = {
+    challenge: decode(obj.challenge) as Uint8Array<ArrayBuffer>,
   };
-  if ("allowCredentials" in options) {
-    obj.allowCredentials = options.allowCredentials?.map((c) => ({
-      id: encodeUrl(ArrayBuffer.isView(c.id) ? c.id.buffer : c.id),
-      type: c.type,
+  if (obj.allowCredentials) {
+    options.allowCredentials = obj.allowCredentials.map((c) => ({
+      id: decode(c.id) as Uint8Array<ArrayBuffer>,
+      type: c.type as PublicKeyCredentialType,
     }));
   }
-  if ("extensions" in options) {
-    obj.extensions = Object.fromEntries(Object.entries(options.extensions ?? {}));
+  if (obj.extensions) {
+    options.extensions = obj.extensions as AuthenticationExtensionsClientInputs;
   }
-  if ("rpId" in options) {
-    obj.rpId = options.rpId;
+  if (obj.rpId) {
+    options.rpId = obj.rpId;
   }
-  if ("timeout" in options) {
-    obj.timeout = options.timeout;
+  if (obj.timeout) {
+    options.timeout = obj.timeout;
   }
-  if ("userVerification" in options) {
-    obj.userVerification = options.userVerification;
+  if (obj.userVerification) {
+    options.userVerification = obj.userVerification as UserVerificationRequirement;
   }
-  return obj;
+  return options;
 }
 
-export type WebAuthnAuthenticateRequest = {
-  options: WebAuthnAuthenticateOptions;
-  credential: {
-    id: string;
-    type: string;
-    rawId: string;
-    response: {
-      authenticatorData: string;
-      clientDataJSON: string;
-      signature: string;
-      userHandle: string;
-    };
-  };
+export type WebAuthnAuthenticateBeginRequest = {
+  allowCredentials: WebAuthnCredentialDescriptor[];
 };
 
-export async function webAuthnAuthenticate(request: WebAuthnAuthenticateRequest) {
-  const response = await fetch("/api/webauthn-authenticate", {
+export async function webAuthnAuthenticateBegin(
+  request: WebAuthnAuthenticateBeginRequest,
+): Promise<WebAuthnAuthenticateOptions> {
+  const response = await fetch("/api/webauthn/authenticate/begin", {
     method: "POST",
     headers: {
       "Content-Type": "application/json",
     },
     body: JSON.stringify(request),
   });
+  if (!response.ok) {
+    throw `${response.status}: ${await response.text()}`;
+  }
+  return (await response.json()) as WebAuthnAuthenticateOptions;
+}
+
+export type WebAuthnAuthenticateCredential = {
+  id: string;
+  type: string;
+  rawId: string;
+  response: {
+    authenticatorData: string;
+    clientDataJSON: string;
+    signature: string;
+    userHandle: string | null;
+  };
+};
+
+export async function webAuthnAuthenticateFinish(credential: WebAuthnAuthenticateCredential) {
+  const response = await fetch("/api/webauthn/authenticate/finish", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(credential),
+  });
   const text = await response.text();
   if (!response.ok) {
     throw `${response.status}: ${text}`;
@@ -136,72 +304,92 @@ export type WebAuthnRegisterOptions = {
   };
 };
 
-export function toWebAuthnRegisterOptions(
-  options: PublicKeyCredentialCreationOptions,
-): WebAuthnRegisterOptions {
-  const obj: WebAuthnRegisterOptions = {
-    challenge: encodeUrl(
-      ArrayBuffer.isView(options.challenge) ? options.challenge.buffer : options.challenge,
-    ),
-    pubKeyCredParams: options.pubKeyCredParams,
+export function fromWebAuthnRegisterOptions(
+  obj: WebAuthnRegisterOptions,
+): PublicKeyCredentialCreationOptions {
+  // decode always allocates a fresh, non-shared buffer
+  const options: PublicKeyCredentialCreationOptions = {
+    challenge: decode(obj.challenge) as Uint8Array<ArrayBuffer>,
+    pubKeyCredParams: obj.pubKeyCredParams,
     rp: {
-      id: options.rp.id,
-      name: options.rp.name,
+      id: obj.rp.id,
+      name: obj.rp.name,
     },
     user: {
-      id: encodeUrl(ArrayBuffer.isView(options.user.id) ? options.user.id.buffer : options.user.id),
-      displayName: options.user.displayName,
-      name: options.user.name,
+      id: decode(obj.user.id) as Uint8Array<ArrayBuffer>,
+      displayName: obj.user.displayName,
+      name: obj.user.name,
     },
   };
-  if ("attestation" in options) {
-    obj.attestation = options.attestation;
+  if (obj.attestation) {
+    options.attestation = obj.attestation as AttestationConveyancePreference;
   }
-  if ("authenticatorSelection" in options) {
-    const selection = options.authenticatorSelection;
-    obj.authenticatorSelection = {
-      authenticatorAttachment: selection?.authenticatorAttachment,
-      requireResidentKey: selection?.requireResidentKey,
-      residentKey: selection?.residentKey,
-      userVerification: selection?.userVerification,
+  if (obj.authenticatorSelection) {
+    const selection = obj.authenticatorSelection;
+    options.authenticatorSelection = {
+      authenticatorAttachment: (selection.authenticatorAttachment || undefined) as
+        | AuthenticatorAttachment
+        | undefined,
+      requireResidentKey: selection.requireResidentKey,
+      residentKey: selection.residentKey as ResidentKeyRequirement | undefined,
+      userVerification: selection.userVerification as UserVerificationRequirement | undefined,
     };
   }
-  if ("excludeCredentials" in options) {
-    obj.excludeCredentials = options.excludeCredentials?.map((c) => ({
-      id: encodeUrl(ArrayBuffer.isView(c.id) ? c.id.buffer : c.id),
-      type: c.type,
+  if (obj.excludeCredentials) {
+    options.excludeCredentials = obj.excludeCredentials.map((c) => ({
+      id: decode(c.id) as Uint8Array<ArrayBuffer>,
+      type: c.type as PublicKeyCredentialType,
     }));
   }
-  if ("extensions" in options) {
-    obj.extensions = Object.fromEntries(Object.entries(options.extensions ?? {}));
+  if (obj.extensions) {
+    options.extensions = obj.extensions as AuthenticationExtensionsClientInputs;
   }
-  if ("timeout" in options) {
-    obj.timeout = options.timeout;
+  if (obj.timeout) {
+    options.timeout = obj.timeout;
   }
-  return obj;
+  return options;
 }
 
-export type WebAuthnRegisterRequest = {
-  options: WebAuthnRegisterOptions;
-  credential: {
-    id: string;
-    type: string;
-    rawId: string;
-    response: {
-      attestationObject: string;
-      clientDataJSON: string;
-    };
-  };
+export type WebAuthnRegisterBeginRequest = {
+  username: string;
+  attestation?: AttestationConveyancePreference;
+  authenticatorAttachment?: AuthenticatorAttachment;
 };
 
-export async function webAuthnRegister(request: WebAuthnRegisterRequest) {
-  const response = await fetch("/api/webauthn-register", {
+export async function webAuthnRegisterBegin(
+  request: WebAuthnRegisterBeginRequest,
+): Promise<WebAuthnRegisterOptions> {
+  const response = await fetch("/api/webauthn/register/begin", {
     method: "POST",
     headers: {
       "Content-Type": "application/json",
     },
     body: JSON.stringify(request),
   });
+  if (!response.ok) {
+    throw `${response.status}: ${await response.text()}`;
+  }
+  return (await response.json()) as WebAuthnRegisterOptions;
+}
+
+export type WebAuthnRegisterCredential = {
+  id: string;
+  type: string;
+  rawId: string;
+  response: {
+    attestationObject: string;
+    clientDataJSON: string;
+  };
+};
+
+export async function webAuthnRegisterFinish(credential: WebAuthnRegisterCredential) {
+  const response = await fetch("/api/webauthn/register/finish", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(credential),
+  });
   const text = await response.text();
   if (!response.ok) {
     throw `${response.status}: ${text}`;
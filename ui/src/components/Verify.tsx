@@ -2,10 +2,13 @@ import LogoutIcon from "@mui/icons-material/Logout";
 import { type FC, useEffect, useState } from "react";
 
 import { type VerifyInfo, fetchVerifyInfo } from "../api";
+import VerifyClientCertificate from "./VerifyClientCertificate";
 import VerifyHeaders from "./VerifyHeaders";
+import VerifyIdentityChain from "./VerifyIdentityChain";
 import VerifyIdentityToken from "./VerifyIdentityToken";
 import VerifyRequestDetails from "./VerifyRequestDetails";
 import VerifyStatus from "./VerifyStatus";
+import VerifyTLS from "./VerifyTLS";
 
 const Verify: FC = () => {
   const [info, setInfo] = useState<VerifyInfo>();
@@ -31,6 +34,9 @@ const Verify: FC = () => {
       <div className="content">
         <VerifyStatus info={info} />
         <VerifyIdentityToken info={info} />
+        <VerifyClientCertificate info={info} />
+        <VerifyIdentityChain info={info} />
+        <VerifyTLS info={info} />
         <VerifyHeaders info={info} />
         <VerifyRequestDetails info={info} />
       </div>
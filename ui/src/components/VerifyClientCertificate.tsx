@@ -0,0 +1,91 @@
+import { type FC } from "react";
+
+import { type VerifyInfo } from "../api";
+import { Certificates } from "./VerifyTLS";
+
+type Props = {
+  info?: VerifyInfo;
+};
+const VerifyClientCertificate: FC<Props> = ({ info }) => {
+  const clientCert = info?.clientCertificate;
+  if (!clientCert) {
+    return <></>;
+  }
+
+  return (
+    <div className="category white box">
+      <div className="messages">
+        <div className="box-inner">
+          <div className="category-header clearfix">
+            <span className="category-title">Client Certificate</span>
+            <a href="/json">
+              <span className="json-icon"></span>
+            </a>
+          </div>
+          <table>
+            <thead>
+              <tr>
+                <th>Mode</th>
+                <th>{clientCert.mode}</th>
+              </tr>
+            </thead>
+            <tbody>
+              <tr>
+                <td>Presented</td>
+                <td>{clientCert.presented ? "Yes" : "No"}</td>
+              </tr>
+              <tr>
+                <td>Verified</td>
+                <td>
+                  {clientCert.verified ? (
+                    "Yes"
+                  ) : (
+                    <>
+                      <p>No</p>
+                      <p>
+                        <code>{clientCert.error}</code>
+                      </p>
+                    </>
+                  )}
+                </td>
+              </tr>
+              {clientCert.spiffeId ? (
+                <tr>
+                  <td>SPIFFE ID</td>
+                  <td>
+                    <code>{clientCert.spiffeId}</code>
+                  </td>
+                </tr>
+              ) : null}
+              {clientCert.uris?.length ? (
+                <tr>
+                  <td>URI SANs</td>
+                  <td>
+                    {clientCert.uris.map((uri) => (
+                      <p key={uri}>
+                        <code>{uri}</code>
+                      </p>
+                    ))}
+                  </td>
+                </tr>
+              ) : null}
+              {clientCert.anchorFile ? (
+                <tr>
+                  <td>Trust Anchor</td>
+                  <td>{clientCert.anchorFile}</td>
+                </tr>
+              ) : null}
+            </tbody>
+          </table>
+          {clientCert.certificates.length ? (
+            <Certificates title="Presented Certificates" certificates={clientCert.certificates} />
+          ) : null}
+          {clientCert.verifiedChain?.length ? (
+            <Certificates title="Verified Chain" certificates={clientCert.verifiedChain} />
+          ) : null}
+        </div>
+      </div>
+    </div>
+  );
+};
+export default VerifyClientCertificate;
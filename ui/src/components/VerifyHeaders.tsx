@@ -1,12 +1,23 @@
 import { type FC } from "react";
 
-import { type VerifyInfo } from "../api";
+import { type VerifyInfo, type VerifyInfoClaimHeaderCheck } from "../api";
+
+const statusLabels: Record<VerifyInfoClaimHeaderCheck["status"], string> = {
+  match: "Matches JWT",
+  mismatch: "Does not match JWT",
+  missing_header: "No header",
+  missing_claim: "Not in JWT",
+};
 
 type Props = {
   info?: VerifyInfo;
 };
 const VerifyHeaders: FC<Props> = ({ info }) => {
   const headers = Object.entries(info?.headers || {}) || [];
+  const checks = info?.claimHeaders?.checks || [];
+  const getCheck = (header: string) =>
+    checks.find((c) => c.header?.toLowerCase() === header.toLowerCase());
+  const missingHeaders = checks.filter((c) => c.status === "missing_header");
 
   return (
     <div className="category white box">
@@ -26,17 +37,40 @@ const VerifyHeaders: FC<Props> = ({ info }) => {
                 <tr>
                   <th>Header</th>
                   <th></th>
+                  {checks.length ? <th>Signed Claim</th> : null}
                 </tr>
               </thead>
               <tbody>
-                {headers?.map(([k, vs]) => (
-                  <tr key={k}>
-                    <td>{k}</td>
+                {headers?.map(([k, vs]) => {
+                  const check = getCheck(k);
+                  return (
+                    <tr key={k}>
+                      <td>{k}</td>
+                      <td>
+                        {vs?.map((v) => (
+                          <p key={v}>{v}</p>
+                        ))}
+                      </td>
+                      {checks.length ? (
+                        <td>
+                          {check ? <p>{statusLabels[check.status]}</p> : null}
+                          {check?.status === "mismatch" ? (
+                            <p>
+                              <code>{check.claimValue}</code>
+                            </p>
+                          ) : null}
+                        </td>
+                      ) : null}
+                    </tr>
+                  );
+                })}
+                {missingHeaders.map((c) => (
+                  <tr key={c.claim}>
                     <td>
-                      {vs?.map((v) => (
-                        <p key={v}>{v}</p>
-                      ))}
+                      <em>{c.claim}</em>
                     </td>
+                    <td></td>
+                    <td>{statusLabels[c.status]}</td>
                   </tr>
                 ))}
               </tbody>
@@ -44,6 +78,14 @@ const VerifyHeaders: FC<Props> = ({ info }) => {
           ) : (
             <>No headers found!</>
           )}
+          {info?.claimHeaders && !info.claimHeaders.consistent ? (
+            <p>
+              <strong>
+                Some headers do not match the signed JWT claims. Check the{" "}
+                <code>jwt_claims_headers</code> setting, and make sure clients cannot set these headers.
+              </strong>
+            </p>
+          ) : null}
         </div>
         <div className="category-link">
           Pomerium allows{" "}
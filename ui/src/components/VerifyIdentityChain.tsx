@@ -0,0 +1,90 @@
+import { type FC } from "react";
+
+import { type VerifyInfo } from "../api";
+
+type Props = {
+  info?: VerifyInfo;
+};
+const VerifyIdentityChain: FC<Props> = ({ info }) => {
+  const chain = info?.chain || [];
+  const warnings = info?.headerWarnings || [];
+
+  // a single hop is already shown by the identity section
+  if (chain.length < 2 && !warnings.length) {
+    return <></>;
+  }
+
+  return (
+    <div className="category white box">
+      <div className="messages">
+        <div className="box-inner">
+          <div className="category-header clearfix">
+            <span className="category-title">Identity Chain</span>
+            <a href="/json">
+              <span className="json-icon"></span>
+            </a>
+          </div>
+          {chain.length ? (
+            <table>
+              <thead>
+                <tr>
+                  <th>Hop</th>
+                  <th>Source</th>
+                  <th>Issuer</th>
+                  <th>User</th>
+                  <th>Verified</th>
+                </tr>
+              </thead>
+              <tbody>
+                {chain.map((hop, i) => (
+                  <tr key={i}>
+                    <td>{i + 1}</td>
+                    <td>{hop.source}</td>
+                    <td>{hop.identity?.iss}</td>
+                    <td>{hop.identity?.email || hop.identity?.sub}</td>
+                    <td>
+                      {hop.verified ? (
+                        "Yes"
+                      ) : (
+                        <>
+                          <p>No</p>
+                          <p>
+                            <code>{hop.error}</code>
+                          </p>
+                          {hop.diagnosis?.hint ? <p>{hop.diagnosis.hint}</p> : null}
+                        </>
+                      )}
+                    </td>
+                  </tr>
+                ))}
+              </tbody>
+            </table>
+          ) : null}
+          {warnings.length ? (
+            <table>
+              <thead>
+                <tr>
+                  <th>Identity Header</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {warnings.map((w) => (
+                  <tr key={w.code + w.header}>
+                    <td>{w.header}</td>
+                    <td>{w.informational ? w.message : <strong>{w.message}</strong>}</td>
+                  </tr>
+                ))}
+              </tbody>
+            </table>
+          ) : null}
+        </div>
+        <div className="category-link">
+          When Pomerium routes to another Pomerium, the original user's assertion is passed in{" "}
+          <code>X-Pomerium-Jwt-Assertion-For</code>. The last hop is the end user.
+        </div>
+      </div>
+    </div>
+  );
+};
+export default VerifyIdentityChain;
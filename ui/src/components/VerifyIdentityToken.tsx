@@ -95,6 +95,124 @@ const VerifyIdentityToken: FC<Props> = ({ info }) => {
               </tbody>
             </table>
           </ul>
+          {info?.claims ? (
+            <table>
+              <thead>
+                <tr>
+                  <th>{info.claims.verified ? "All Claims" : "All Claims (unverified)"}</th>
+                  <th>Type</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {Object.keys(info.claims.claims)
+                  .sort()
+                  .map((k) => (
+                    <tr key={k}>
+                      <td>{k}</td>
+                      <td>{info.claims?.types[k]}</td>
+                      <td>
+                        <code>{JSON.stringify(info.claims?.claims[k])}</code>
+                      </td>
+                    </tr>
+                  ))}
+              </tbody>
+            </table>
+          ) : (
+            <></>
+          )}
+          {info?.time ? (
+            <table>
+              <thead>
+                <tr>
+                  <th>Time</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                <tr>
+                  <td>Server Time</td>
+                  <td>{info.time.now}</td>
+                </tr>
+                <tr>
+                  <td>Skew (since iat)</td>
+                  <td>{info.time.skew}</td>
+                </tr>
+                <tr>
+                  <td>Leeway</td>
+                  <td>{info.time.leeway}</td>
+                </tr>
+                <tr>
+                  <td>Valid With Leeway</td>
+                  <td>{info.time.validWithLeeway ? "Yes" : "No"}</td>
+                </tr>
+                <tr>
+                  <td>Valid Without Leeway</td>
+                  <td>{info.time.validWithoutLeeway ? "Yes" : "No"}</td>
+                </tr>
+              </tbody>
+            </table>
+          ) : (
+            <></>
+          )}
+          {info?.jwksEndpoint ? (
+            <table>
+              <thead>
+                <tr>
+                  <th>JWKS Endpoint</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                <tr>
+                  <td>URL</td>
+                  <td>{info.jwksEndpoint.url}</td>
+                </tr>
+                <tr>
+                  <td>Source</td>
+                  <td>{info.jwksEndpoint.source}</td>
+                </tr>
+                {info.jwksEndpoint.discoveryUrl ? (
+                  <tr>
+                    <td>Discovery URL</td>
+                    <td>{info.jwksEndpoint.discoveryUrl}</td>
+                  </tr>
+                ) : (
+                  <></>
+                )}
+                {info.jwksEndpoint.error ? (
+                  <tr>
+                    <td>Discovery Error</td>
+                    <td>{info.jwksEndpoint.error}</td>
+                  </tr>
+                ) : (
+                  <></>
+                )}
+              </tbody>
+            </table>
+          ) : (
+            <></>
+          )}
+          {info?.jwtHeader ? (
+            <table>
+              <thead>
+                <tr>
+                  <th>Header</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {Object.entries(info.jwtHeader).map(([k, v]) => (
+                  <tr key={k}>
+                    <td>{k}</td>
+                    <td>{v}</td>
+                  </tr>
+                ))}
+              </tbody>
+            </table>
+          ) : (
+            <></>
+          )}
         </div>
         <div className="category-link">
           Pomerium adds a signed JWT token to the incoming request headers (
@@ -20,6 +20,13 @@ const VerifyStatus: FC<Props> = ({ info }) => {
                 </span>
                 <code>{info?.error}</code>
               </label>
+              {info?.diagnosis?.hint ? (
+                <label className="status-time">
+                  <span>{info.diagnosis.hint}</span>
+                </label>
+              ) : (
+                <></>
+              )}
             </div>
           </>
         ) : info?.request?.tlsError ? (
@@ -0,0 +1,244 @@
+import { type FC } from "react";
+
+import { type VerifyInfo, type VerifyInfoCertificate } from "../api";
+
+type CertificatesProps = {
+  title: string;
+  certificates: VerifyInfoCertificate[];
+};
+export const Certificates: FC<CertificatesProps> = ({ title, certificates }) => {
+  return (
+    <table>
+      <thead>
+        <tr>
+          <th>{title}</th>
+          <th></th>
+        </tr>
+      </thead>
+      <tbody>
+        {certificates.map((cert) => (
+          <tr key={cert.sha256Fingerprint}>
+            <td>{cert.subject}</td>
+            <td>
+              <p>Issuer: {cert.issuer}</p>
+              {cert.sans?.length ? <p>SANs: {cert.sans.join(", ")}</p> : null}
+              <p>Serial: {cert.serialNumber}</p>
+              <p>
+                Valid: {cert.notBefore} – {cert.notAfter}
+              </p>
+              <p>
+                Key: {cert.keyAlgorithm} ({cert.signatureAlgorithm})
+              </p>
+              <p>
+                SHA-256: <code>{cert.sha256Fingerprint}</code>
+              </p>
+              <p>
+                SPKI SHA-256: <code>{cert.spkiSha256}</code>
+              </p>
+            </td>
+          </tr>
+        ))}
+      </tbody>
+    </table>
+  );
+};
+
+type Props = {
+  info?: VerifyInfo;
+};
+const VerifyTLS: FC<Props> = ({ info }) => {
+  const tls = info?.tls;
+  const caCerts = info?.caCerts || [];
+  if (!tls && !caCerts.length) {
+    return <></>;
+  }
+
+  return (
+    <div className="category white box">
+      <div className="messages">
+        <div className="box-inner">
+          <div className="category-header clearfix">
+            <span className="category-title">JWKS Host Certificates</span>
+            <a href="/json">
+              <span className="json-icon"></span>
+            </a>
+          </div>
+          {tls ? (
+            <>
+              <table>
+                <thead>
+                  <tr>
+                    <th>Server</th>
+                    <th>{tls.serverName}</th>
+                  </tr>
+                </thead>
+                <tbody>
+                  <tr>
+                    <td>Verified</td>
+                    <td>
+                      {tls.verified ? (
+                        "Yes"
+                      ) : (
+                        <>
+                          <p>No</p>
+                          <p>
+                            <code>{tls.error}</code>
+                          </p>
+                        </>
+                      )}
+                    </td>
+                  </tr>
+                  {tls.missingIssuer ? (
+                    <tr>
+                      <td>Missing Issuer</td>
+                      <td>{tls.missingIssuer}</td>
+                    </tr>
+                  ) : null}
+                  {tls.anchorSource ? (
+                    <tr>
+                      <td>Trust Anchor</td>
+                      <td>{tls.anchorSource === "file" ? tls.anchorFile : "System"}</td>
+                    </tr>
+                  ) : null}
+                  {tls.pin ? (
+                    <tr>
+                      <td>Pinned Keys</td>
+                      <td>
+                        {tls.pin.matched ? (
+                          <p>
+                            Matched <code>{tls.pin.matchedPin}</code> ({tls.pin.matchedSubject})
+                          </p>
+                        ) : (
+                          <p>No certificate matches the pinned keys</p>
+                        )}
+                      </td>
+                    </tr>
+                  ) : null}
+                  {tls.clientCertificate ? (
+                    <tr>
+                      <td>Client Certificate</td>
+                      <td>
+                        <p>
+                          {tls.clientCertificate.requested
+                            ? "Requested by the server"
+                            : "Not requested by the server"}
+                        </p>
+                        {tls.clientCertificate.sent ? (
+                          <p>
+                            Sent {tls.clientCertificate.subject} (expires{" "}
+                            {tls.clientCertificate.notAfter})
+                          </p>
+                        ) : null}
+                        {tls.clientCertificate.accepted !== undefined ? (
+                          <p>{tls.clientCertificate.accepted ? "Accepted" : "Rejected"}</p>
+                        ) : null}
+                        {tls.clientCertificate.error ? (
+                          <p>
+                            <code>{tls.clientCertificate.error}</code>
+                          </p>
+                        ) : null}
+                      </td>
+                    </tr>
+                  ) : null}
+                  {tls.notAfter ? (
+                    <tr>
+                      <td>Expires</td>
+                      <td>
+                        <p>
+                          {tls.notAfter} ({tls.expiresIn})
+                        </p>
+                        {tls.expiryWarning ? (
+                          <p>Warning: the certificate chain expires soon.</p>
+                        ) : null}
+                      </td>
+                    </tr>
+                  ) : null}
+                  <tr>
+                    <td>Checked</td>
+                    <td>{tls.time}</td>
+                  </tr>
+                </tbody>
+              </table>
+              {tls.revocation?.length ? (
+                <table>
+                  <thead>
+                    <tr>
+                      <th>Revocation</th>
+                      <th></th>
+                    </tr>
+                  </thead>
+                  <tbody>
+                    {tls.revocation.map((r) => (
+                      <tr key={r.subject}>
+                        <td>{r.subject}</td>
+                        <td>
+                          <p>
+                            {r.status}
+                            {r.source ? ` (${r.source}${r.url ? `: ${r.url}` : ""})` : ""}
+                          </p>
+                          {r.revokedAt ? (
+                            <p>
+                              Revoked {r.revokedAt}: {r.reason}
+                            </p>
+                          ) : null}
+                          {r.error ? (
+                            <p>
+                              <code>{r.error}</code>
+                            </p>
+                          ) : null}
+                        </td>
+                      </tr>
+                    ))}
+                  </tbody>
+                </table>
+              ) : null}
+              <Certificates title="Presented Certificates" certificates={tls.certificates} />
+              {tls.verifiedChain?.length ? (
+                <Certificates title="Verified Chain" certificates={tls.verifiedChain} />
+              ) : null}
+            </>
+          ) : null}
+          {caCerts.length ? (
+            <table>
+              <thead>
+                <tr>
+                  <th>CA Cert File</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {caCerts.map((f) => (
+                  <tr key={(f.issuer || "") + f.path}>
+                    <td>
+                      <p>{f.path}</p>
+                      {f.issuer ? <p>Issuer: {f.issuer}</p> : null}
+                    </td>
+                    <td>
+                      {f.error ? (
+                        <p>
+                          <code>{f.error}</code>
+                        </p>
+                      ) : (
+                        <p>Loaded {f.loadedAt}</p>
+                      )}
+                      {f.certificates.map((c) => (
+                        <p key={c.sha256Fingerprint}>
+                          {c.subject}: <code>{c.sha256Fingerprint}</code>
+                        </p>
+                      ))}
+                    </td>
+                  </tr>
+                ))}
+              </tbody>
+            </table>
+          ) : null}
+        </div>
+        <div className="category-link">
+          The certificates presented by the host serving the signing keys on the last connection.
+          Extra trusted CAs are set with <code>EXTRA_CA_CERTS</code>.
+        </div>
+      </div>
+    </div>
+  );
+};
+export default VerifyTLS;
@@ -11,10 +11,12 @@ import TextField from "@mui/material/TextField";
 import React, { type FC, useState } from "react";
 
 import {
-  toWebAuthnAuthenticateOptions,
-  toWebAuthnRegisterOptions,
-  webAuthnAuthenticate,
-  webAuthnRegister,
+  fromWebAuthnAuthenticateOptions,
+  fromWebAuthnRegisterOptions,
+  webAuthnAuthenticateBegin,
+  webAuthnAuthenticateFinish,
+  webAuthnRegisterBegin,
+  webAuthnRegisterFinish,
 } from "../api";
 
 function getKnownCredentials(): Uint8Array<ArrayBuffer>[] {
@@ -40,35 +42,28 @@ function addKnownCredential(rawID: ArrayBuffer) {
   localStorage.setItem("known-credentials", JSON.stringify(Array.from(set.values())));
 }
 
-async function authenticate(username: string) {
-  const challenge = crypto.getRandomValues(new Uint8Array(32));
-  const options: PublicKeyCredentialRequestOptions = {
-    allowCredentials: getKnownCredentials().map((rawID) => ({
-      type: "public-key",
-      id: rawID,
-    })),
-    challenge: challenge,
-    rpId: location.hostname,
-    userVerification: "preferred",
-  };
+async function authenticate() {
+  const options = fromWebAuthnAuthenticateOptions(
+    await webAuthnAuthenticateBegin({
+      allowCredentials: getKnownCredentials().map((rawID) => ({
+        type: "public-key",
+        id: encodeUrl(rawID),
+      })),
+    }),
+  );
   const credential = (await navigator.credentials.get({
     publicKey: options,
   })) as PublicKeyCredential;
   const credentialResponse = credential.response as AuthenticatorAssertionResponse;
-  await webAuthnAuthenticate({
-    options: toWebAuthnAuthenticateOptions(options),
-    credential: {
-      id: credential.id,
-      type: credential.type,
-      rawId: encodeUrl(credential.rawId),
-      response: {
-        authenticatorData: encodeUrl(credentialResponse.authenticatorData),
-        clientDataJSON: encodeUrl(credentialResponse.clientDataJSON),
-        signature: encodeUrl(credentialResponse.signature),
-        userHandle: encodeUrl(
-          credentialResponse.userHandle || Uint8Array.from(username, (c) => c.charCodeAt(0)),
-        ),
-      },
+  await webAuthnAuthenticateFinish({
+    id: credential.id,
+    type: credential.type,
+    rawId: encodeUrl(credential.rawId),
+    response: {
+      authenticatorData: encodeUrl(credentialResponse.authenticatorData),
+      clientDataJSON: encodeUrl(credentialResponse.clientDataJSON),
+      signature: encodeUrl(credentialResponse.signature),
+      userHandle: credentialResponse.userHandle ? encodeUrl(credentialResponse.userHandle) : null,
     },
   });
 }
@@ -78,45 +73,25 @@ async function register(
   attestationType?: AttestationConveyancePreference,
   authenticatorAttachment?: AuthenticatorAttachment,
 ) {
-  const challenge = crypto.getRandomValues(new Uint8Array(32));
-  const options: PublicKeyCredentialCreationOptions = {
-    attestation: attestationType || undefined,
-    authenticatorSelection: {
-      authenticatorAttachment: authenticatorAttachment || undefined,
-      residentKey: "preferred",
-      userVerification: "preferred",
-    },
-    challenge: challenge,
-    pubKeyCredParams: [
-      { alg: -65535, type: "public-key" },
-      { alg: -257, type: "public-key" },
-      { alg: -7, type: "public-key" },
-    ],
-    rp: {
-      id: location.hostname,
-      name: "Pomerium",
-    },
-    user: {
-      id: Uint8Array.from(username, (c) => c.charCodeAt(0)),
-      name: username,
-      displayName: username,
-    },
-  };
+  const options = fromWebAuthnRegisterOptions(
+    await webAuthnRegisterBegin({
+      username: username,
+      attestation: attestationType,
+      authenticatorAttachment: authenticatorAttachment,
+    }),
+  );
   const credential = (await navigator.credentials.create({
     publicKey: options,
   })) as PublicKeyCredential;
   const credentialResponse = credential.response as AuthenticatorAttestationResponse;
   addKnownCredential(credential.rawId);
-  await webAuthnRegister({
-    options: toWebAuthnRegisterOptions(options),
-    credential: {
-      id: credential.id,
-      type: credential.type,
-      rawId: encodeUrl(credential.rawId),
-      response: {
-        attestationObject: encodeUrl(credentialResponse.attestationObject),
-        clientDataJSON: encodeUrl(credentialResponse.clientDataJSON),
-      },
+  await webAuthnRegisterFinish({
+    id: credential.id,
+    type: credential.type,
+    rawId: encodeUrl(credential.rawId),
+    response: {
+      attestationObject: encodeUrl(credentialResponse.attestationObject),
+      clientDataJSON: encodeUrl(credentialResponse.clientDataJSON),
     },
   });
 }
@@ -158,7 +133,7 @@ const WebAuthn: FC = () => {
     (async () => {
       setResult(undefined);
       try {
-        await authenticate(username);
+        await authenticate();
         setResult({
           severity: "success",
           message: `Authentication Successful!`,
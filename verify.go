@@ -2,15 +2,16 @@ package verify
 
 import (
 	"context"
-	"crypto/x509"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
-	"os"
 
 	"cloud.google.com/go/firestore"
 	"github.com/go-chi/chi"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 
 	"github.com/pomerium/verify/internal/storage"
 )
@@ -19,40 +20,180 @@ import (
 type Server struct {
 	cfg *config
 
-	http        *http.Server
-	router      chi.Router
-	storage     storage.Backend
-	tlsVerifier *tlsVerifier
+	http            *http.Server
+	httpRedirect    *http.Server
+	servingCert     *keyPair
+	clientCAs       *caCertPool
+	router          chi.Router
+	storage         storage.Backend
+	defaultVerifier *issuerVerifier
+	issuerVerifiers map[string]*issuerVerifier
+	tokenSources    []tokenSource
+
+	webAuthnBeginLimiter *rate.Limiter
 }
 
 // New creates a new Server.
 func New(options ...Option) *Server {
 	cfg := getConfig(options...)
 
-	var verifierOpts tlsVerifierOptions
-	if len(cfg.extraCACerts) > 0 {
-		pool, err := x509.SystemCertPool()
+	srv, err := newVerifierServer(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	if cfg.tlsCertFile != "" || cfg.tlsKeyFile != "" {
+		srv.servingCert = newKeyPair("serving certificate", cfg.tlsCertFile, cfg.tlsKeyFile)
+		if _, err := srv.servingCert.load(); err != nil {
+			log.Fatal().Err(err).Str("cert", cfg.tlsCertFile).Msg("failed to load TLS certificate")
+		}
+	}
+	switch cfg.tlsClientAuth {
+	case "":
+	case tlsClientAuthRequest, tlsClientAuthRequire:
+		if srv.servingCert == nil {
+			log.Fatal().Msg("client certificates can only be requested when serving HTTPS")
+		}
+		if len(cfg.tlsClientCACerts) == 0 {
+			log.Fatal().Msg("client CA certs are required to validate client certificates")
+		}
+		srv.clientCAs = newCACertPool(cfg.tlsClientCACerts, false)
+	default:
+		log.Fatal().Str("mode", cfg.tlsClientAuth).Msg("invalid TLS client auth mode (expected request or require)")
+	}
+	srv.webAuthnBeginLimiter = rate.NewLimiter(webAuthnBeginRate, webAuthnBeginBurst)
+	for _, raw := range cfg.tokenSources {
+		ts, err := parseTokenSource(raw)
 		if err != nil {
-			log.Fatal().Err(err).Msg("failed to load system CA certs")
+			log.Fatal().Err(err).Send()
+		}
+		srv.tokenSources = append(srv.tokenSources, ts)
+	}
+	return srv
+}
+
+// newVerifierServer creates a Server with only the pieces needed to verify
+// JWTs: the issuer verifiers and the config they use. Unlike New it doesn't
+// load the serving certificate, so it can be used to check tokens offline.
+func newVerifierServer(cfg *config) (*Server, error) {
+	for _, alg := range cfg.allowedSigningAlgorithms {
+		if !isAsymmetricSigningAlgorithm(alg) {
+			return nil, fmt.Errorf("invalid allowed signing algorithm %q (expected an asymmetric JWS algorithm)", alg)
+		}
+	}
+	if len(cfg.trustedIssuers) > 0 && cfg.expectedJWTIssuer != "" {
+		// every trusted issuer would otherwise expect the same issuer claim
+		return nil, fmt.Errorf("an expected JWT issuer (%s) can't be combined with trusted issuers, which each expect their own issuer",
+			cfg.expectedJWTIssuer)
+	}
+	if len(cfg.trustedIssuers) > 0 {
+		// these only configure the default verifier, which isn't used once
+		// there are trusted issuers
+		for _, option := range []struct{ name, value string }{
+			{"JWKS endpoint", cfg.jwksEndpoint},
+			{"JWKS file", cfg.jwksFile},
+			{"JWKS data", cfg.jwksData},
+			{"OIDC discovery URL", cfg.oidcDiscoveryURL},
+		} {
+			if option.value != "" {
+				return nil, fmt.Errorf("a %s can't be combined with trusted issuers, which each have their own JWKS endpoint",
+					option.name)
+			}
 		}
-		for _, certPath := range cfg.extraCACerts {
-			cert, err := os.ReadFile(certPath)
+	}
+
+	verifierOpts := tlsVerifierOptions{
+		strict:        cfg.strictTLS,
+		expiryWarning: cfg.certExpiryWarning,
+	}
+	for host, pins := range cfg.spkiPins {
+		for _, pin := range pins {
+			pin, err := parseSPKIPin(pin)
 			if err != nil {
-				log.Fatal().Err(err).Str("path", certPath).Msg("failed to read CA cert")
-			} else {
-				log.Info().Str("path", certPath).Msg("adding extra CA cert")
+				return nil, fmt.Errorf("invalid SPKI pin for %s: %w", host, err)
 			}
-			ok := pool.AppendCertsFromPEM(cert)
-			if !ok {
-				log.Warn().Str("path", certPath).Msg("no CA certs found in file")
+			if verifierOpts.spkiPins == nil {
+				verifierOpts.spkiPins = make(map[string][]string)
 			}
+			verifierOpts.spkiPins[host] = append(verifierOpts.spkiPins[host], pin)
 		}
-		verifierOpts.rootCAs = pool
 	}
-	return &Server{
-		cfg:         cfg,
-		tlsVerifier: newTLSVerifier(verifierOpts),
+	if cfg.revocationCheck {
+		rc, err := newRevocationChecker(cfg.crlFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CRL files: %w", err)
+		}
+		verifierOpts.revocation = rc
+	}
+	if len(cfg.extraCACerts) > 0 {
+		verifierOpts.caCerts = newCACertPool(cfg.extraCACerts, true)
+	}
+	if cfg.clientCertFile != "" || cfg.clientKeyFile != "" {
+		verifierOpts.clientCert = newKeyPair("client certificate", cfg.clientCertFile, cfg.clientKeyFile)
+		if _, err := verifierOpts.clientCert.load(); err != nil {
+			log.Error().Err(err).Str("cert", cfg.clientCertFile).Msg("failed to load client certificate")
+		}
+	}
+	defaultTLSVerifier := newTLSVerifier(verifierOpts)
+
+	srv := &Server{
+		cfg:             cfg,
+		defaultVerifier: newIssuerVerifier("", cfg.jwksEndpoint, cfg.jwksCacheTTL, defaultTLSVerifier),
+		issuerVerifiers: make(map[string]*issuerVerifier),
+	}
+	switch {
+	case cfg.jwksFile != "":
+		srv.defaultVerifier.staticJWKS = newStaticJWKSFile(cfg.jwksFile)
+		srv.defaultVerifier.jwksEndpoint = srv.defaultVerifier.staticJWKS.url
+	case cfg.jwksData != "":
+		srv.defaultVerifier.staticJWKS = newStaticJWKSData([]byte(cfg.jwksData))
+		srv.defaultVerifier.jwksEndpoint = srv.defaultVerifier.staticJWKS.url
 	}
+	// the default verifier isn't used once there are trusted issuers, which
+	// each discover their own JWKS endpoint
+	if cfg.oidcDiscovery && srv.defaultVerifier.jwksEndpoint == "" && len(cfg.trustedIssuers) == 0 {
+		if cfg.expectedJWTIssuer == "" {
+			return nil, errors.New("OIDC discovery requires an expected JWT issuer or trusted issuers, " +
+				"so that tokens can't choose the discovery URL")
+		}
+		srv.defaultVerifier.discovery = newOIDCDiscovery(cfg.expectedJWTIssuer, cfg.oidcDiscoveryURL, defaultTLSVerifier)
+	}
+	for _, ti := range cfg.trustedIssuers {
+		tlsVerifier := defaultTLSVerifier
+		if len(ti.caCerts) > 0 {
+			tlsVerifier = newTLSVerifier(tlsVerifierOptions{
+				strict:        cfg.strictTLS,
+				expiryWarning: cfg.certExpiryWarning,
+				revocation:    verifierOpts.revocation,
+				spkiPins:      verifierOpts.spkiPins,
+				caCerts:       newCACertPool(ti.caCerts, false),
+				clientCert:    verifierOpts.clientCert,
+			})
+		}
+		log.Info().
+			Str("issuer", ti.issuer).
+			Str("jwks-endpoint", ti.jwksEndpoint).
+			Msg("adding trusted issuer")
+		iv := newIssuerVerifier(ti.issuer, ti.jwksEndpoint, cfg.jwksCacheTTL, tlsVerifier)
+		if cfg.oidcDiscovery && ti.jwksEndpoint == "" {
+			iv.discovery = newOIDCDiscovery(ti.issuer, "", tlsVerifier)
+		}
+		srv.issuerVerifiers[normalizeIssuer(ti.issuer)] = iv
+	}
+	return srv, nil
+}
+
+// initIssuerVerifiers initializes every issuer verifier, loading any static
+// JWKS.
+func (srv *Server) initIssuerVerifiers() error {
+	expected := newJWTExpected(srv.cfg)
+	for _, iv := range srv.getAllIssuerVerifiers() {
+		err := iv.init(expected, srv.cfg.jwtLeeway, srv.cfg.allowedSigningAlgorithms)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Run runs the server.
@@ -64,11 +205,67 @@ func (srv *Server) Run(ctx context.Context) error {
 		return err
 	}
 
+	if iv := srv.defaultVerifier; iv.staticJWKS != nil && iv.staticJWKS.path != "" {
+		eg.Go(func() error {
+			iv.watchJWKSFile(ctx)
+			return nil
+		})
+	}
+
+	for _, pool := range srv.getCACertPools() {
+		eg.Go(func() error {
+			pool.watch(ctx)
+			return nil
+		})
+	}
+
+	if rc := srv.defaultVerifier.tlsVerifier.revocation; rc != nil && len(rc.crlPaths) > 0 {
+		eg.Go(func() error {
+			rc.watch(ctx)
+			return nil
+		})
+	}
+
+	if cc := srv.defaultVerifier.tlsVerifier.clientCert; cc != nil {
+		eg.Go(func() error {
+			cc.watch(ctx)
+			return nil
+		})
+	}
+
+	if srv.servingCert == nil {
+		eg.Go(func() error {
+			log.Info().
+				Str("bind-addr", srv.cfg.bindAddress).
+				Msg("starting http server")
+			return srv.http.ListenAndServe()
+		})
+		return eg.Wait()
+	}
+
+	eg.Go(func() error {
+		srv.servingCert.watch(ctx)
+		return nil
+	})
+	if srv.clientCAs != nil {
+		eg.Go(func() error {
+			srv.clientCAs.watch(ctx)
+			return nil
+		})
+	}
+	if srv.httpRedirect != nil {
+		eg.Go(func() error {
+			log.Info().
+				Str("bind-addr", srv.httpRedirect.Addr).
+				Msg("starting http redirect server")
+			return srv.httpRedirect.ListenAndServe()
+		})
+	}
 	eg.Go(func() error {
 		log.Info().
 			Str("bind-addr", srv.cfg.bindAddress).
-			Msg("starting http server")
-		return srv.http.ListenAndServe()
+			Msg("starting https server")
+		return srv.http.ListenAndServeTLS("", "")
 	})
 	return eg.Wait()
 }
@@ -94,6 +291,15 @@ func (srv *Server) init(ctx context.Context) error {
 		},
 		Handler: srv.router,
 	}
+	if srv.servingCert != nil {
+		srv.http.TLSConfig = srv.getTLSConfig()
+		if srv.cfg.httpRedirectAddress != "" {
+			srv.httpRedirect = &http.Server{
+				Addr:    srv.cfg.httpRedirectAddress,
+				Handler: newHTTPSRedirectHandler(srv.cfg.bindAddress),
+			}
+		}
+	}
 
 	return nil
 }